@@ -0,0 +1,443 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loft-sh/vcluster/config"
+	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	DiscoveryControllerName = "vcluster-discovery"
+
+	configSecretNamePrefix = "vc-config-"
+
+	// discoveryRequeueInterval is used to restart mappers of vClusters that
+	// stopped because of an error
+	discoveryRequeueInterval = time.Minute
+)
+
+// centralMapper runs a mapper for every vCluster on the host cluster that
+// uses the central host path mapper. All mappers share the cluster wide
+// physical pod cache of the local manager.
+type centralMapper struct {
+	// ctx is the context the mappers of the vClusters are started with
+	ctx context.Context
+
+	options      *VirtualClusterOptions
+	kubeClient   kubernetes.Interface
+	localManager manager.Manager
+
+	m         sync.Mutex
+	instances map[types.NamespacedName]*centralInstance
+
+	// removals are the deletion guards of the virtual paths of deleted
+	// vClusters, which are cleaned up over consecutive discoveries
+	removals map[types.NamespacedName]*deletionGuard
+}
+
+type centralInstance struct {
+	cancel context.CancelFunc
+
+	// done is closed once the mapper stopped
+	done chan struct{}
+
+	// config the mapper was started with, a changed config restarts it
+	config *config.Config
+}
+
+func startCentral(ctx context.Context, inClusterConfig *rest.Config, options *VirtualClusterOptions, init bool) error {
+	kubeClient, err := kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		return fmt.Errorf("create kube client: %w", err)
+	}
+
+	localManager, err := newLocalManager(inClusterConfig, options, cache.Options{})
+	if err != nil {
+		return err
+	}
+
+	startManager(ctx, localManager)
+
+	if init {
+		klog.Info("is init container mode")
+		return restartCentralTargetPods(ctx, options, kubeClient, localManager)
+	}
+
+	c := &centralMapper{
+		ctx:          ctx,
+		options:      options,
+		kubeClient:   kubeClient,
+		localManager: localManager,
+		instances:    map[types.NamespacedName]*centralInstance{},
+		removals:     map[types.NamespacedName]*deletionGuard{},
+	}
+
+	// we only need the names of the config secrets, so there is no need to
+	// cache the data of every secret in the host cluster
+	mappedVClusters := make(chan event.GenericEvent)
+	err = ctrl.NewControllerManagedBy(localManager).
+		Named(DiscoveryControllerName).
+		For(&corev1.Secret{}, builder.OnlyMetadata, builder.WithPredicates(predicate.NewPredicateFuncs(func(obj client.Object) bool {
+			return strings.HasPrefix(obj.GetName(), configSecretNamePrefix)
+		}))).
+		WatchesRawSource(source.Channel(mappedVClusters, &handler.EnqueueRequestForObject{})).
+		Complete(c)
+	if err != nil {
+		return fmt.Errorf("register discovery controller: %w", err)
+	}
+
+	err = localManager.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return c.enqueueMappedVClusters(ctx, mappedVClusters)
+	}))
+	if err != nil {
+		return fmt.Errorf("add virtual paths discovery: %w", err)
+	}
+
+	klog.Info("mapping hostpaths of all vClusters using the central hostpath mapper")
+	<-ctx.Done()
+	return nil
+}
+
+// restartCentralTargetPods restarts the target pods of every vCluster that
+// uses the central host path mapper
+func restartCentralTargetPods(ctx context.Context, options *VirtualClusterOptions, kubeClient kubernetes.Interface, localManager manager.Manager) error {
+	secrets, err := kubeClient.CoreV1().Secrets("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("list vCluster config secrets: %w", err)
+	}
+
+	for _, secret := range secrets.Items {
+		if !strings.HasPrefix(secret.Name, configSecretNamePrefix) {
+			continue
+		}
+
+		vClusterConfig, err := parseVclusterConfigSecret(&secret)
+		if err != nil {
+			klog.Errorf("error parsing vCluster config secret %s/%s: %v", secret.Namespace, secret.Name, err)
+			continue
+		} else if !usesCentralMapper(vClusterConfig) {
+			continue
+		}
+
+		vClusterOptions, err := newCentralInstanceOptions(options, secret.Namespace, strings.TrimPrefix(secret.Name, configSecretNamePrefix), vClusterConfig)
+		if err != nil {
+			klog.Errorf("error creating options for vCluster %s/%s: %v", secret.Namespace, secret.Name, err)
+			continue
+		}
+
+		err = restartTargetPods(context.WithValue(ctx, optionsKey, vClusterOptions), vClusterOptions, kubeClient, localManager.GetClient())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func usesCentralMapper(vClusterConfig *config.Config) bool {
+	return vClusterConfig.ControlPlane.HostPathMapper.Enabled && vClusterConfig.ControlPlane.HostPathMapper.Central
+}
+
+// newCentralInstanceOptions returns a copy of the central options for a
+// single vCluster
+func newCentralInstanceOptions(options *VirtualClusterOptions, namespace, name string, vClusterConfig *config.Config) (*VirtualClusterOptions, error) {
+	vClusterOptions := *options
+	vClusterOptions.Name = name
+	vClusterOptions.TargetNamespace = namespace
+	setVirtualPaths(&vClusterOptions)
+
+	translator, err := newTranslator(&vClusterOptions, vClusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	vClusterOptions.translator = translator
+	return &vClusterOptions, nil
+}
+
+func (c *centralMapper) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	key := types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      strings.TrimPrefix(req.Name, configSecretNamePrefix),
+	}
+
+	vClusterConfig, err := getVclusterConfigFromSecret(ctx, c.kubeClient, key.Name, key.Namespace)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			// the vCluster was deleted, so its virtual paths are no longer used
+			c.stop(key)
+			if !c.removeVirtualPaths(ctx, key) {
+				return reconcile.Result{RequeueAfter: discoveryRequeueInterval}, nil
+			}
+
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	if !usesCentralMapper(vClusterConfig) {
+		c.stop(key)
+		return reconcile.Result{}, nil
+	}
+
+	err = c.ensureStarted(key, vClusterConfig)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	return reconcile.Result{RequeueAfter: discoveryRequeueInterval}, nil
+}
+
+func (c *centralMapper) ensureStarted(key types.NamespacedName, vClusterConfig *config.Config) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	delete(c.removals, key)
+	if instance, ok := c.instances[key]; ok {
+		if reflect.DeepEqual(instance.config, vClusterConfig) {
+			return nil
+		}
+
+		klog.Infof("config of vCluster %s changed, restarting its mapper", key)
+		instance.cancel()
+		delete(c.instances, key)
+	}
+
+	options, err := newCentralInstanceOptions(c.options, key.Namespace, key.Name, vClusterConfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	instance := &centralInstance{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		config: vClusterConfig,
+	}
+	c.instances[key] = instance
+	health.discover(key.String())
+
+	klog.Infof("starting mapper for vCluster %s", key)
+	go func() {
+		defer close(instance.done)
+		defer cancel()
+
+		err := c.runInstance(ctx, options)
+		if err != nil && ctx.Err() == nil {
+			klog.Errorf("error mapping hostpaths of vCluster %s: %v", key, err)
+		}
+
+		// the discovery controller starts a new mapper on its next requeue
+		c.m.Lock()
+		if c.instances[key] == instance {
+			delete(c.instances, key)
+			health.reset(key.String())
+		}
+		c.m.Unlock()
+	}()
+
+	return nil
+}
+
+// stop stops the mapper of the vCluster and waits until it finished, the
+// virtual paths are kept
+func (c *centralMapper) stop(key types.NamespacedName) {
+	c.m.Lock()
+	instance, ok := c.instances[key]
+	delete(c.instances, key)
+	c.m.Unlock()
+
+	if !ok {
+		return
+	}
+
+	klog.Infof("stopping mapper for vCluster %s", key)
+	instance.cancel()
+	<-instance.done
+	deleteVClusterMetrics(key.String())
+	health.remove(key.String())
+	ownership.remove(key.String())
+}
+
+// removeVirtualPaths cleans up the virtual paths of a deleted vCluster and
+// returns whether it is done. Like a resync without any pods, it removes
+// only the paths the mapper owns, once their retention period passed and the
+// deletion guard allows it. Directories are removed once they are empty, so
+// entries the mapper did not create are kept.
+func (c *centralMapper) removeVirtualPaths(ctx context.Context, key types.NamespacedName) bool {
+	options := *c.options
+	options.Name = key.Name
+	options.TargetNamespace = key.Namespace
+	setVirtualPaths(&options)
+
+	_, err := options.fs().Stat(options.VirtualRootPath)
+	if os.IsNotExist(err) {
+		c.forgetRemoval(key)
+		return true
+	}
+
+	c.m.Lock()
+	guard, ok := c.removals[key]
+	if !ok {
+		guard = newDeletionGuard(&options)
+		c.removals[key] = guard
+	}
+	c.m.Unlock()
+
+	ctx = context.WithValue(ctx, optionsKey, &options)
+	plan := cleanupVirtualPaths(ctx, map[string]bool{}, map[string]map[string]bool{}, map[string]bool{}, guard)
+	if len(plan.retention.Orphaned) > 0 {
+		klog.Infof("keeping %d virtual paths of deleted vCluster %s for now", len(plan.retention.Orphaned), key)
+		return false
+	}
+
+	removeEmptyVirtualDirectories(&options)
+	c.forgetRemoval(key)
+	return true
+}
+
+func (c *centralMapper) forgetRemoval(key types.NamespacedName) {
+	c.m.Lock()
+	delete(c.removals, key)
+	c.m.Unlock()
+
+	deleteVClusterMetrics(key.String())
+	ownership.remove(key.String())
+}
+
+// removeEmptyVirtualDirectories removes the state of the mapper and the
+// directories of the virtual paths that are empty
+func removeEmptyVirtualDirectories(options *VirtualClusterOptions) {
+	fsys := options.fs()
+	paths := []string{
+		options.VirtualPodLogsPath,
+		options.VirtualContainerLogsPath,
+		options.VirtualLogsPath,
+		options.VirtualKubeletPodPath,
+		filepath.Dir(options.VirtualKubeletPodPath),
+		// the state goes last, so an interrupted cleanup is found again
+		retentionStatePath(options),
+		ownershipStatePath(options),
+		options.VirtualRootPath,
+	}
+
+	for _, path := range paths {
+		if _, err := fsys.Lstat(path); err != nil {
+			continue
+		}
+
+		if options.DryRun {
+			reportDryRun(options, dryRunAction{VCluster: vClusterKey(options), Action: dryRunActionDelete, Path: path})
+			continue
+		}
+
+		err := fsys.Remove(path)
+		if err != nil {
+			klog.Infof("keeping %s of the deleted vCluster %s: %v", path, vClusterKey(options), err)
+		} else {
+			klog.Infof("cleaning up %s", path)
+		}
+	}
+}
+
+// hasMapperState returns whether the virtual root of the options contains a
+// state of the mapper
+func hasMapperState(options *VirtualClusterOptions) bool {
+	for _, path := range []string{ownershipStatePath(options), retentionStatePath(options)} {
+		if _, err := options.fs().Lstat(path); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// enqueueMappedVClusters enqueues every vCluster whose virtual paths contain
+// a state of the mapper, so the paths of vClusters that were deleted while
+// the mapper was not running are cleaned up as well
+func (c *centralMapper) enqueueMappedVClusters(ctx context.Context, events chan<- event.GenericEvent) error {
+	fsys := c.options.fs()
+	virtualPath := c.options.layout().virtualPath
+	for _, namespace := range readDirNames(fsys, virtualPath) {
+		for _, name := range readDirNames(fsys, filepath.Join(virtualPath, namespace)) {
+			options := *c.options
+			options.Name = name
+			options.TargetNamespace = namespace
+			setVirtualPaths(&options)
+			if !hasMapperState(&options) {
+				continue
+			}
+
+			secret := &metav1.PartialObjectMetadata{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: configSecretNamePrefix + name}}
+			select {
+			case events <- event.GenericEvent{Object: secret}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// runInstance maps the host paths of a single vCluster until ctx is done
+func (c *centralMapper) runInstance(ctx context.Context, options *VirtualClusterOptions) error {
+	err := ensureVirtualPaths(options)
+	if err != nil {
+		return err
+	}
+
+	loadConfig := func(ctx context.Context) (*rest.Config, error) {
+		return getCentralVirtualClusterConfig(ctx, c.kubeClient, options)
+	}
+
+	return runWithCredentialReload(ctx, options, loadConfig, func(ctx context.Context, virtualClusterConfig *rest.Config) error {
+		return mapHostPaths(ctx, options, c.localManager, virtualClusterConfig)
+	})
+}
+
+// getCentralVirtualClusterConfig builds the config to reach the vCluster
+// through its service from the credentials in the vc-<name> secret
+func getCentralVirtualClusterConfig(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) (*rest.Config, error) {
+	secretName := kubeconfig.GetDefaultSecretName(options.Name)
+	secret, err := kubeClient.CoreV1().Secrets(options.TargetNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get vCluster secret %s/%s: %w", options.TargetNamespace, secretName, err)
+	}
+
+	return &rest.Config{
+		Host: fmt.Sprintf("%s.%s", options.Name, options.TargetNamespace),
+		TLSClientConfig: rest.TLSClientConfig{
+			ServerName: options.Name,
+			CertData:   secret.Data[kubeconfig.CertificateSecretKey],
+			KeyData:    secret.Data[kubeconfig.CertificateKeySecretKey],
+			CAData:     secret.Data[kubeconfig.CADataSecretKey],
+		},
+	}, nil
+}
@@ -0,0 +1,112 @@
+package hostpaths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+// newTestCentralHost returns a test host whose virtual paths are in the
+// layout of the central mapper, with the mapper that manages them
+func newTestCentralHost(t *testing.T) (*testHost, *centralMapper) {
+	h := newTestHost(t)
+	h.options.host.virtualPath = filepath.Join(t.TempDir(), "virtual")
+	setVirtualPaths(h.options)
+	assert.NilError(t, ensureVirtualPaths(h.options))
+	h.virtualPath = h.options.VirtualRootPath
+	t.Cleanup(func() { deleteVClusterMetrics(vClusterKey(h.options)) })
+
+	options := *h.options
+	options.Central = true
+	options.MaxDeletionFraction = 1
+	return h, &centralMapper{options: &options, removals: map[types.NamespacedName]*deletionGuard{}}
+}
+
+func Test_centralRemoveVirtualPaths(t *testing.T) {
+	h, c := newTestCentralHost(t)
+	key := types.NamespacedName{Namespace: "vcluster-ns", Name: "vcluster"}
+
+	vPod, pPod := h.addPod("app")
+	goneVPod, gonePPod := h.addPod("gone")
+	h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+	assert.Assert(t, len(h.virtualPaths()) > 0)
+
+	// the mapper restarted after the vCluster was deleted
+	ownership.remove(key.String())
+	h.removePodLogs(gonePPod)
+	h.removeKubeletDir(gonePPod)
+	foreignPath := filepath.Join(h.options.VirtualPodLogsPath, "foreign")
+	assert.NilError(t, os.WriteFile(foreignPath, []byte("foreign"), 0644))
+
+	// the paths of the pod whose files still exist are retained
+	assert.Assert(t, !c.removeVirtualPaths(context.Background(), key))
+	paths := h.virtualPaths()
+	for path := range paths {
+		assert.Assert(t, !strings.HasPrefix(path, "kubelet/pods/"+string(goneVPod.UID)), path)
+	}
+	_, ok := paths["kubelet/pods/"+string(vPod.UID)]
+	assert.Assert(t, ok)
+
+	h.removePodLogs(pPod)
+	h.removeKubeletDir(pPod)
+	assert.Assert(t, c.removeVirtualPaths(context.Background(), key))
+
+	// the directories with foreign entries are kept, the rest is removed
+	assert.DeepEqual(t, readDirNames(osFS{}, h.options.VirtualPodLogsPath), []string{"foreign"})
+	for _, path := range []string{
+		h.options.VirtualContainerLogsPath,
+		filepath.Dir(h.options.VirtualKubeletPodPath),
+		ownershipStatePath(h.options),
+		retentionStatePath(h.options),
+	} {
+		_, err := os.Lstat(path)
+		assert.Assert(t, os.IsNotExist(err), path)
+	}
+
+	// nothing is left to clean up once the root is gone
+	assert.NilError(t, os.RemoveAll(h.options.VirtualRootPath))
+	assert.Assert(t, c.removeVirtualPaths(context.Background(), key))
+}
+
+func Test_centralRemoveVirtualPathsDryRun(t *testing.T) {
+	h, c := newTestCentralHost(t)
+	c.options.DryRun = true
+	key := types.NamespacedName{Namespace: "vcluster-ns", Name: "vcluster"}
+
+	vPod, pPod := h.addPod("app")
+	h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+	paths := h.virtualPaths()
+
+	h.removePodLogs(pPod)
+	h.removeKubeletDir(pPod)
+	c.removeVirtualPaths(context.Background(), key)
+	assert.DeepEqual(t, h.virtualPaths(), paths)
+}
+
+func Test_enqueueMappedVClusters(t *testing.T) {
+	h, c := newTestCentralHost(t)
+	vPod, pPod := h.addPod("app")
+	h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+
+	// a directory of the layout that was not created by the mapper
+	assert.NilError(t, os.MkdirAll(h.options.layout().vClusterPath("other-ns", "other"), 0755))
+
+	events := make(chan event.GenericEvent, 10)
+	assert.NilError(t, c.enqueueMappedVClusters(context.Background(), events))
+	close(events)
+
+	var enqueued []string
+	for e := range events {
+		secret := e.Object.(*metav1.PartialObjectMetadata)
+		enqueued = append(enqueued, secret.Namespace+"/"+secret.Name)
+	}
+	assert.DeepEqual(t, enqueued, []string{"vcluster-ns/" + configSecretNamePrefix + "vcluster"})
+}
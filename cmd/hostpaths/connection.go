@@ -0,0 +1,126 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// addVirtualConnectionFlags adds the flags that replace the mounted
+// certificates and the service of the vCluster as the way to reach it
+func addVirtualConnectionFlags(flags *pflag.FlagSet, options *VirtualClusterOptions) {
+	flags.StringVar(&options.VirtualKubeConfig, "virtual-kubeconfig", "", "The path to a kubeconfig of the virtual cluster, replaces the certificate flags")
+	flags.StringVar(&options.VirtualKubeConfigSecret, "virtual-kubeconfig-secret", "", "The secret in the target namespace with the kubeconfig of the virtual cluster in its config key, e.g. vc-<name>, replaces the certificate flags")
+	flags.StringVar(&options.VirtualServer, "server", "", "The url of the virtual cluster api server, overrides the server of the certificates or kubeconfig, e.g. for a vcluster exposed through a load balancer")
+}
+
+// validateConnectionOptions checks that the options select a single way to
+// reach the virtual cluster
+func validateConnectionOptions(options *VirtualClusterOptions) error {
+	if options.Central && (options.VirtualKubeConfig != "" || options.VirtualKubeConfigSecret != "" || options.VirtualServer != "") {
+		return fmt.Errorf("--virtual-kubeconfig, --virtual-kubeconfig-secret and --server can't be used with --central, the central mapper reaches every vcluster through its service")
+	} else if options.VirtualKubeConfig != "" && options.VirtualKubeConfigSecret != "" {
+		return fmt.Errorf("only one of --virtual-kubeconfig and --virtual-kubeconfig-secret can be set")
+	}
+
+	return nil
+}
+
+// getVirtualClusterConfig returns the config to reach the virtual cluster.
+// It is read from the kubeconfig file or secret if set, otherwise the
+// vCluster is reached through its service with the mounted certificates.
+func getVirtualClusterConfig(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) (*rest.Config, error) {
+	if options.Central {
+		return getCentralVirtualClusterConfig(ctx, kubeClient, options)
+	}
+
+	var (
+		virtualClusterConfig *rest.Config
+		err                  error
+	)
+	switch {
+	case options.VirtualKubeConfig != "":
+		virtualClusterConfig, err = clientcmd.BuildConfigFromFlags("", options.VirtualKubeConfig)
+		if err != nil {
+			return nil, fmt.Errorf("load virtual kubeconfig %s: %w", options.VirtualKubeConfig, err)
+		}
+	case options.VirtualKubeConfigSecret != "":
+		virtualClusterConfig, err = getSecretVirtualClusterConfig(ctx, kubeClient, options)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		virtualClusterConfig = newVirtualClusterConfig(options)
+	}
+
+	if options.VirtualServer != "" {
+		virtualClusterConfig.Host = options.VirtualServer
+	}
+
+	return virtualClusterConfig, nil
+}
+
+// newVirtualClusterConfig returns the config to reach the virtual cluster
+// through its service with the certificates mounted into the mapper
+func newVirtualClusterConfig(options *VirtualClusterOptions) *rest.Config {
+	return &rest.Config{
+		Host: options.Name,
+		TLSClientConfig: rest.TLSClientConfig{
+			ServerName: options.Name,
+			CertFile:   options.ClientCaCert,
+			KeyFile:    options.ServerCaKey,
+			CAFile:     options.ServerCaCert,
+		},
+	}
+}
+
+// getSecretVirtualClusterConfig reads the kubeconfig from the secret the
+// vCluster exports it to. The kubeconfig of the vc-<name> secret points to
+// localhost, which is replaced by the service of the vCluster.
+func getSecretVirtualClusterConfig(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) (*rest.Config, error) {
+	secretName := options.VirtualKubeConfigSecret
+	secret, err := kubeClient.CoreV1().Secrets(options.TargetNamespace).Get(ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get virtual kubeconfig secret %s/%s: %w", options.TargetNamespace, secretName, err)
+	}
+
+	data, ok := secret.Data[kubeconfig.KubeconfigSecretKey]
+	if !ok {
+		return nil, fmt.Errorf("virtual kubeconfig secret %s/%s has no %s key", options.TargetNamespace, secretName, kubeconfig.KubeconfigSecretKey)
+	}
+
+	virtualClusterConfig, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse virtual kubeconfig of secret %s/%s: %w", options.TargetNamespace, secretName, err)
+	}
+
+	if isLocalhost(virtualClusterConfig.Host) {
+		virtualClusterConfig.Host = fmt.Sprintf("https://%s.%s", options.Name, options.TargetNamespace)
+		virtualClusterConfig.ServerName = options.Name
+	}
+
+	return virtualClusterConfig, nil
+}
+
+func isLocalhost(host string) bool {
+	serverURL, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+
+	hostname := serverURL.Hostname()
+	if hostname == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(hostname)
+	return ip != nil && ip.IsLoopback()
+}
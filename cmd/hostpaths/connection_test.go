@@ -0,0 +1,122 @@
+package hostpaths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/loft-sh/vcluster/pkg/util/kubeconfig"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func testKubeconfig(t *testing.T, server string) []byte {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["vcluster"] = &clientcmdapi.Cluster{Server: server, CertificateAuthorityData: []byte("ca")}
+	config.AuthInfos["vcluster"] = &clientcmdapi.AuthInfo{ClientCertificateData: []byte("cert"), ClientKeyData: []byte("key")}
+	config.Contexts["vcluster"] = &clientcmdapi.Context{Cluster: "vcluster", AuthInfo: "vcluster"}
+	config.CurrentContext = "vcluster"
+
+	data, err := clientcmd.Write(*config)
+	assert.NilError(t, err)
+	return data
+}
+
+func Test_getVirtualClusterConfig(t *testing.T) {
+	kubeconfigPath := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.NilError(t, os.WriteFile(kubeconfigPath, testKubeconfig(t, "https://127.0.0.1:30443"), 0600))
+
+	testTable := []struct {
+		name    string
+		options VirtualClusterOptions
+		secrets map[string][]byte
+
+		expectedHost       string
+		expectedServerName string
+		expectedCertFile   string
+		expectedErr        string
+	}{
+		{
+			name:               "certificates",
+			expectedHost:       "vcluster",
+			expectedServerName: "vcluster",
+			expectedCertFile:   "/data/server/tls/client-certificate",
+		},
+		{
+			name:               "certificates with server",
+			options:            VirtualClusterOptions{VirtualServer: "https://vcluster.example.com:8443"},
+			expectedHost:       "https://vcluster.example.com:8443",
+			expectedServerName: "vcluster",
+			expectedCertFile:   "/data/server/tls/client-certificate",
+		},
+		{
+			name:         "kubeconfig file",
+			options:      VirtualClusterOptions{VirtualKubeConfig: kubeconfigPath},
+			expectedHost: "https://127.0.0.1:30443",
+		},
+		{
+			name:         "kubeconfig file with server",
+			options:      VirtualClusterOptions{VirtualKubeConfig: kubeconfigPath, VirtualServer: "https://10.0.0.1"},
+			expectedHost: "https://10.0.0.1",
+		},
+		{
+			name:               "secret pointing to localhost",
+			options:            VirtualClusterOptions{VirtualKubeConfigSecret: "vc-vcluster"},
+			secrets:            map[string][]byte{"vc-vcluster": testKubeconfig(t, "https://localhost:8443")},
+			expectedHost:       "https://vcluster.vcluster-ns",
+			expectedServerName: "vcluster",
+		},
+		{
+			name:         "secret with exported server",
+			options:      VirtualClusterOptions{VirtualKubeConfigSecret: "vc-vcluster"},
+			secrets:      map[string][]byte{"vc-vcluster": testKubeconfig(t, "https://vcluster.example.com")},
+			expectedHost: "https://vcluster.example.com",
+		},
+		{
+			name:        "secret without kubeconfig",
+			options:     VirtualClusterOptions{VirtualKubeConfigSecret: "vc-vcluster"},
+			secrets:     map[string][]byte{"vc-vcluster": nil},
+			expectedErr: "has no config key",
+		},
+		{
+			name:        "missing secret",
+			options:     VirtualClusterOptions{VirtualKubeConfigSecret: "vc-vcluster"},
+			expectedErr: `secrets "vc-vcluster" not found`,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			for name, data := range testCase.secrets {
+				secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vcluster-ns"}, Data: map[string][]byte{}}
+				if data != nil {
+					secret.Data[kubeconfig.KubeconfigSecretKey] = data
+				}
+				_, err := kubeClient.CoreV1().Secrets("vcluster-ns").Create(context.Background(), secret, metav1.CreateOptions{})
+				assert.NilError(t, err)
+			}
+
+			options := testCase.options
+			options.Name = "vcluster"
+			options.TargetNamespace = "vcluster-ns"
+			options.ClientCaCert = "/data/server/tls/client-certificate"
+
+			config, err := getVirtualClusterConfig(context.Background(), kubeClient, &options)
+			if testCase.expectedErr != "" {
+				assert.ErrorContains(t, err, testCase.expectedErr)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, config.Host, testCase.expectedHost)
+			assert.Equal(t, config.ServerName, testCase.expectedServerName)
+			assert.Equal(t, config.CertFile, testCase.expectedCertFile)
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	ControllerName = "hostpath-mapper"
+
+	// pendingRequeueInterval is used when the physical pod exists but kubelet
+	// has not created its log directory yet, which we cannot watch for
+	pendingRequeueInterval = 2 * time.Second
+)
+
+// resyncRequest is never produced by a pod event (pods always have a name)
+// and triggers a full pass over all pods on the node including cleanup
+var resyncRequest = reconcile.Request{}
+
+type podReconciler struct {
+	options  *VirtualClusterOptions
+	pManager manager.Manager
+	vManager manager.Manager
+
+	health    *mappingHealth
+	guard     *deletionGuard
+	retries   *mapRetries
+	podEvents *podEvents
+
+	// resyncEvents enqueues the resyncRequest, it holds at most one pending
+	// event as the queue merges the requests anyway
+	resyncEvents chan event.GenericEvent
+}
+
+// registerMapperController sets up the controller that maps a single virtual
+// pod whenever it or its physical counterpart changes, plus a periodic full
+// resync as a safety net
+func registerMapperController(options *VirtualClusterOptions, pManager, vManager manager.Manager) error {
+	podEvents := newPodEvents(options, vManager.GetEventRecorderFor(ControllerName))
+	r := &podReconciler{
+		options:   options,
+		pManager:  pManager,
+		vManager:  vManager,
+		health:    health.forVCluster(vClusterKey(options)),
+		guard:     newDeletionGuard(options),
+		retries:   newMapRetries(podEvents),
+		podEvents: podEvents,
+
+		resyncEvents: make(chan event.GenericEvent, 1),
+	}
+
+	err := vManager.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return triggerResync(ctx, options.ResyncInterval, r.resyncEvents)
+	}))
+	if err != nil {
+		return fmt.Errorf("add resync runnable: %w", err)
+	}
+
+	onNode := predicate.NewPredicateFuncs(isPodOnCurrentNode)
+
+	return ctrl.NewControllerManagedBy(vManager).
+		Named(ControllerName).
+		// in central mode there is one controller per vCluster in this process
+		WithOptions(controller.Options{
+			SkipNameValidation: ptr.To(true),
+			RateLimiter:        newMapRateLimiter(),
+		}).
+		For(&corev1.Pod{}, builder.WithPredicates(onNode)).
+		WatchesRawSource(&physicalPodSource{
+			cache: pManager.GetCache(),
+			filter: func(pPod *corev1.Pod) bool {
+				return isPodOnCurrentNode(pPod) && options.translator.IsTargetedNamespace(nil, pPod.Namespace)
+			},
+		}).
+		WatchesRawSource(source.Channel(r.resyncEvents,
+			handler.EnqueueRequestsFromMapFunc(func(context.Context, client.Object) []reconcile.Request {
+				return []reconcile.Request{resyncRequest}
+			}))).
+		WatchesRawSource(r.retries.source()).
+		Complete(r)
+}
+
+// triggerResync requests a full resync once at startup, so paths left over
+// from a previous run get cleaned up, and then every interval
+func triggerResync(ctx context.Context, interval time.Duration, events chan<- event.GenericEvent) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case events <- event.GenericEvent{Object: &corev1.Pod{}}:
+		case <-ctx.Done():
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// requestResync enqueues the resyncRequest unless it is pending already
+func (r *podReconciler) requestResync() {
+	select {
+	case r.resyncEvents <- event.GenericEvent{Object: &corev1.Pod{}}:
+	default:
+	}
+}
+
+// physicalPodSource enqueues the virtual pods of changed physical pods.
+// Unlike source.Kind it removes its event handler from the physical cache
+// again once the controller stops, as in central mode the physical manager
+// outlives the controllers of the vClusters that come and go.
+type physicalPodSource struct {
+	cache  cache.Cache
+	filter func(pPod *corev1.Pod) bool
+}
+
+func (s *physicalPodSource) Start(ctx context.Context, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) error {
+	informer, err := s.cache.GetInformer(ctx, &corev1.Pod{})
+	if err != nil {
+		return fmt.Errorf("get physical pod informer: %w", err)
+	}
+
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+
+		pPod, ok := obj.(*corev1.Pod)
+		if !ok || !s.filter(pPod) {
+			return
+		}
+
+		for _, req := range physicalToVirtualPod(ctx, pPod) {
+			queue.Add(req)
+		}
+	}
+
+	registration, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    enqueue,
+		UpdateFunc: func(_, obj interface{}) { enqueue(obj) },
+		DeleteFunc: enqueue,
+	})
+	if err != nil {
+		return fmt.Errorf("add physical pod event handler: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		err := informer.RemoveEventHandler(registration)
+		if err != nil {
+			klog.Errorf("error removing physical pod event handler: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+func (s *physicalPodSource) String() string {
+	return "physical pod source"
+}
+
+func isPodOnCurrentNode(obj client.Object) bool {
+	pod, ok := obj.(*corev1.Pod)
+	return ok && pod.Spec.NodeName == os.Getenv(HostpathMapperSelfNodeNameEnvVar)
+}
+
+// physicalToVirtualPod uses the annotations the syncer sets on physical pods
+// to find the virtual pod that needs to be reconciled
+func physicalToVirtualPod(_ context.Context, pPod *corev1.Pod) []reconcile.Request {
+	name := pPod.Annotations[translate.NameAnnotation]
+	namespace := pPod.Annotations[translate.NamespaceAnnotation]
+	if name == "" || namespace == "" {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: namespace}}}
+}
+
+func (r *podReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	ctx = context.WithValue(ctx, optionsKey, r.options)
+
+	reconcileType := reconcileTypePod
+	if req == resyncRequest {
+		reconcileType = reconcileTypeResync
+	}
+
+	startTime := time.Now()
+	r.health.startReconcile()
+	result, err := r.reconcile(ctx, req)
+	r.health.finishReconcile(err)
+	reconcileDuration.WithLabelValues(vClusterLabel(ctx), reconcileType).Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		reconcileErrors.WithLabelValues(vClusterLabel(ctx), reconcileType).Inc()
+	}
+
+	return result, err
+}
+
+func (r *podReconciler) reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	if req == resyncRequest {
+		return reconcile.Result{}, resyncHostPaths(ctx, r.pManager, r.vManager, r.guard, r.retries, r.podEvents)
+	}
+
+	vPod := &corev1.Pod{}
+	err := r.vManager.GetClient().Get(ctx, req.NamespacedName, vPod)
+	if err != nil {
+		if kerrors.IsNotFound(err) {
+			// the pod is gone, its paths get cleaned up by a full pass, which
+			// is shared by all pods that are removed in the meantime
+			r.requestResync()
+			return reconcile.Result{}, nil
+		}
+
+		return reconcile.Result{}, err
+	}
+
+	if !isPodOnCurrentNode(vPod) {
+		return reconcile.Result{}, nil
+	}
+
+	hostNamespace := r.options.translator.HostNamespace(nil, vPod.Namespace)
+	if hostNamespace == "" {
+		klog.Infof("no host namespace found for virtual namespace %s, skipping pod %s", vPod.Namespace, vPod.Name)
+		return reconcile.Result{}, nil
+	}
+
+	podIndex, err := getPhysicalPodIndex(ctx, r.pManager.GetClient(), map[string]struct{}{hostNamespace: {}})
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	pPod := podIndex.lookup(r.options.translator, vPod)
+	if pPod == nil {
+		// we get triggered again once the physical pod is created
+		r.podEvents.physicalPodNotFound(vPod)
+		return reconcile.Result{}, nil
+	}
+
+	podDetail, ok := getPodDetail(r.options, *pPod)
+	if !ok {
+		klog.V(1).Infof("log directory for physical pod %s does not exist yet", pPod.Name)
+		r.podEvents.logDirectoryNotFound(vPod, pPod)
+		return reconcile.Result{RequeueAfter: pendingRequeueInterval}, nil
+	}
+
+	mapping, err := mapVirtualPod(ctx, *vPod, podDetail)
+	if saveErr := saveOwnedPaths(r.options); saveErr != nil {
+		klog.Errorf("error saving owned paths: %v", saveErr)
+	}
+	if err != nil {
+		// the controller retries the pod with backoff
+		r.retries.failed(ctx, vPod, err)
+		return reconcile.Result{}, err
+	}
+
+	r.podEvents.mapped(vPod, mapping)
+
+	return reconcile.Result{}, nil
+}
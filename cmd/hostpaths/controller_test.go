@@ -0,0 +1,80 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// notFoundClient is a client of a virtual cluster whose pods are all gone
+type notFoundClient struct {
+	client.Client
+}
+
+func (notFoundClient) Get(_ context.Context, key client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+	return kerrors.NewNotFound(corev1.Resource("pods"), key.Name)
+}
+
+// testManager is a manager that only provides its client
+type testManager struct {
+	manager.Manager
+	client client.Client
+}
+
+func (m testManager) GetClient() client.Client {
+	return m.client
+}
+
+func Test_reconcileRemovedPods(t *testing.T) {
+	h := newTestHost(t)
+	h.options.MaxDeletionFraction = 0.5
+	h.options.DeletionConfirmations = 3
+	h.options.ResyncInterval = time.Minute
+	t.Cleanup(func() { health.remove(vClusterKey(h.options)) })
+
+	var vPods, pPods []corev1.Pod
+	for i := 0; i < 3; i++ {
+		vPod, pPod := h.addPod(fmt.Sprintf("app-%d", i))
+		vPods = append(vPods, vPod)
+		pPods = append(pPods, pPod)
+	}
+	h.sync(vPods, pPods, true, newMapRetries(newPodEvents(h.options, nil)))
+	paths := h.virtualPaths()
+
+	podEvents := newPodEvents(h.options, nil)
+	r := &podReconciler{
+		options:   h.options,
+		vManager:  testManager{client: notFoundClient{}},
+		health:    health.forVCluster(vClusterKey(h.options)),
+		guard:     newDeletionGuard(h.options),
+		retries:   newMapRetries(podEvents),
+		podEvents: podEvents,
+
+		resyncEvents: make(chan event.GenericEvent, 1),
+	}
+
+	// the virtual cluster returns no pods, e.g. as it was reset
+	for i := 0; i < 50; i++ {
+		req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("app-%d", i%len(vPods))}}
+		_, err := r.Reconcile(context.Background(), req)
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, len(r.resyncEvents), 1)
+	assert.DeepEqual(t, h.virtualPaths(), paths)
+
+	// the resyncs that follow quickly don't confirm the mass deletion
+	for i := 0; i < 10; i++ {
+		syncHostPaths(h.ctx, nil, newPhysicalPodIndex(nil), true, r.guard, r.retries, r.podEvents)
+	}
+	assert.DeepEqual(t, h.virtualPaths(), paths)
+}
@@ -0,0 +1,223 @@
+package hostpaths
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+)
+
+// credentialWaitTimeout is how long the mapper waits for the first
+// credentials of the virtual cluster, e.g. until vcluster created its secret
+var credentialWaitTimeout = time.Hour
+
+// virtualCredentials is a config of the virtual cluster with the fingerprint
+// of its server and credentials
+type virtualCredentials struct {
+	config      *rest.Config
+	fingerprint string
+}
+
+// loadConfigFunc returns the current config of the virtual cluster, e.g. from
+// the mounted certificates or a secret
+type loadConfigFunc func(ctx context.Context) (*rest.Config, error)
+
+// runWithCredentialReload runs the mapper of the virtual cluster with the
+// config returned by loadConfig until ctx is done. The config is loaded again
+// every CredentialReloadInterval and the mapper is restarted with it once the
+// credentials changed, e.g. because the vCluster rotated its certificates.
+// The virtual paths are kept during the switch, the restarted mapper picks
+// them up with its first resync.
+func runWithCredentialReload(ctx context.Context, options *VirtualClusterOptions, loadConfig loadConfigFunc, run func(ctx context.Context, virtualClusterConfig *rest.Config) error) error {
+	credentials, err := waitForCredentials(ctx, options, loadConfig)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		return err
+	}
+
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- run(runCtx, credentials.config)
+		}()
+
+		changed := make(chan *virtualCredentials, 1)
+		if options.CredentialReloadInterval > 0 {
+			go watchCredentials(runCtx, options.CredentialReloadInterval, loadConfig, credentials, changed)
+		}
+
+		select {
+		case err := <-done:
+			cancel()
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		case credentials = <-changed:
+			klog.Infof("credentials of vCluster %s changed, reconnecting", vClusterKey(options))
+			cancel()
+			<-done
+			credentialReloads.WithLabelValues(vClusterKey(options)).Inc()
+		}
+	}
+}
+
+// watchCredentials loads the config every interval and sends it to changed
+// once its credentials differ from the current ones
+func watchCredentials(ctx context.Context, interval time.Duration, loadConfig loadConfigFunc, current *virtualCredentials, changed chan<- *virtualCredentials) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		credentials, err := loadCredentials(ctx, loadConfig)
+		if err != nil {
+			if ctx.Err() == nil {
+				klog.Errorf("error reloading the credentials of the virtual cluster, keeping the current ones: %v", err)
+			}
+			continue
+		}
+
+		if credentials.fingerprint != current.fingerprint {
+			changed <- credentials
+			return
+		}
+	}
+}
+
+// waitForCredentials loads the config until it succeeds, every
+// CredentialReloadInterval or every second if reloading is disabled, and
+// returns the last error once credentialWaitTimeout passed
+func waitForCredentials(ctx context.Context, options *VirtualClusterOptions, loadConfig loadConfigFunc) (*virtualCredentials, error) {
+	interval := options.CredentialReloadInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	var (
+		credentials *virtualCredentials
+		loadErr     error
+	)
+	err := wait.PollUntilContextTimeout(ctx, interval, credentialWaitTimeout, true, func(ctx context.Context) (bool, error) {
+		credentials, loadErr = loadCredentials(ctx, loadConfig)
+		if loadErr != nil {
+			klog.Infof("couldn't load the credentials of vCluster %s (%v), will retry in %s", vClusterKey(options), loadErr, interval)
+			return false, nil
+		}
+
+		return true, nil
+	})
+	if err != nil {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func loadCredentials(ctx context.Context, loadConfig loadConfigFunc) (*virtualCredentials, error) {
+	virtualClusterConfig, err := loadConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := credentialsFingerprint(virtualClusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &virtualCredentials{config: virtualClusterConfig, fingerprint: fingerprint}, nil
+}
+
+// credentialsFingerprint hashes the server and the credentials of the config,
+// including the contents of the certificate and token files it references.
+// Exec and auth provider plugins are hashed by their configuration only, the
+// tokens they return are refreshed by client-go and not reloaded here.
+func credentialsFingerprint(virtualClusterConfig *rest.Config) (string, error) {
+	fingerprint := sha256.New()
+	for _, value := range []string{virtualClusterConfig.Host, virtualClusterConfig.ServerName, virtualClusterConfig.BearerToken} {
+		writeFingerprintField(fingerprint, []byte(value))
+	}
+	for _, value := range pluginFingerprintFields(virtualClusterConfig) {
+		writeFingerprintField(fingerprint, []byte(value))
+	}
+	for _, data := range [][]byte{virtualClusterConfig.CertData, virtualClusterConfig.KeyData, virtualClusterConfig.CAData} {
+		writeFingerprintField(fingerprint, data)
+	}
+
+	for _, path := range []string{virtualClusterConfig.CertFile, virtualClusterConfig.KeyFile, virtualClusterConfig.CAFile, virtualClusterConfig.BearerTokenFile} {
+		if path == "" {
+			writeFingerprintField(fingerprint, nil)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read credentials: %w", err)
+		}
+
+		writeFingerprintField(fingerprint, data)
+	}
+
+	return hex.EncodeToString(fingerprint.Sum(nil)), nil
+}
+
+// pluginFingerprintFields returns the configuration of the exec and auth
+// provider plugins of the config, empty fields if it uses none
+func pluginFingerprintFields(virtualClusterConfig *rest.Config) []string {
+	var fields []string
+	if exec := virtualClusterConfig.ExecProvider; exec != nil {
+		fields = append(fields, exec.APIVersion, exec.Command, strings.Join(exec.Args, "\x00"))
+		for _, env := range exec.Env {
+			fields = append(fields, env.Name+"="+env.Value)
+		}
+	} else {
+		fields = append(fields, "", "", "")
+	}
+
+	if authProvider := virtualClusterConfig.AuthProvider; authProvider != nil {
+		fields = append(fields, authProvider.Name)
+		keys := make([]string, 0, len(authProvider.Config))
+		for key := range authProvider.Config {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fields = append(fields, key+"="+authProvider.Config[key])
+		}
+	} else {
+		fields = append(fields, "")
+	}
+
+	return fields
+}
+
+// writeFingerprintField writes the data with its length, so moving bytes
+// between fields changes the fingerprint
+func writeFingerprintField(fingerprint hash.Hash, data []byte) {
+	_ = binary.Write(fingerprint, binary.BigEndian, uint64(len(data)))
+	_, _ = fingerprint.Write(data)
+}
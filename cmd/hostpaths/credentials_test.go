@@ -0,0 +1,184 @@
+package hostpaths
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func Test_credentialsFingerprint(t *testing.T) {
+	certFile := filepath.Join(t.TempDir(), "client-certificate")
+	assert.NilError(t, os.WriteFile(certFile, []byte("cert"), 0600))
+	config := &rest.Config{Host: "vcluster", TLSClientConfig: rest.TLSClientConfig{CertFile: certFile}}
+
+	fingerprint, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	unchanged, err := credentialsFingerprint(rest.CopyConfig(config))
+	assert.NilError(t, err)
+	assert.Equal(t, unchanged, fingerprint)
+
+	// rotated certificate
+	assert.NilError(t, os.WriteFile(certFile, []byte("rotated"), 0600))
+	rotated, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	assert.Assert(t, rotated != fingerprint)
+
+	// the same bytes in another field
+	moved, err := credentialsFingerprint(&rest.Config{Host: "vcluster", TLSClientConfig: rest.TLSClientConfig{KeyData: []byte("rotated")}})
+	assert.NilError(t, err)
+	withData, err := credentialsFingerprint(&rest.Config{Host: "vcluster", TLSClientConfig: rest.TLSClientConfig{CertData: []byte("rotated")}})
+	assert.NilError(t, err)
+	assert.Assert(t, moved != withData)
+
+	// rotated token and changed exec plugin
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("token"), 0600))
+	config.BearerTokenFile = tokenFile
+	withToken, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("rotated"), 0600))
+	rotatedToken, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	assert.Assert(t, rotatedToken != withToken)
+
+	config.ExecProvider = &clientcmdapi.ExecConfig{Command: "get-token", Args: []string{"--cluster", "vcluster"}}
+	withExec, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	assert.Assert(t, withExec != rotatedToken)
+	config.ExecProvider.Args = []string{"--cluster", "other"}
+	changedExec, err := credentialsFingerprint(config)
+	assert.NilError(t, err)
+	assert.Assert(t, changedExec != withExec)
+
+	assert.NilError(t, os.Remove(certFile))
+	_, err = credentialsFingerprint(config)
+	assert.ErrorContains(t, err, "read credentials")
+}
+
+// testCredentials is a credential source whose certificate can be rotated
+type testCredentials struct {
+	m       sync.Mutex
+	cert    string
+	loadErr error
+
+	// started receives the certificate of every config the mapper runs with
+	started chan string
+}
+
+func (c *testCredentials) rotate(cert string, loadErr error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.cert = cert
+	c.loadErr = loadErr
+}
+
+func (c *testCredentials) load(context.Context) (*rest.Config, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.loadErr != nil {
+		return nil, c.loadErr
+	}
+
+	return &rest.Config{Host: "vcluster", TLSClientConfig: rest.TLSClientConfig{CertData: []byte(c.cert)}}, nil
+}
+
+func (c *testCredentials) run(ctx context.Context, virtualClusterConfig *rest.Config) error {
+	c.started <- string(virtualClusterConfig.CertData)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func expectStarted(t *testing.T, credentials *testCredentials, cert string) {
+	select {
+	case started := <-credentials.started:
+		assert.Equal(t, started, cert)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("mapper was not started with certificate %s", cert)
+	}
+}
+
+func expectNotStarted(t *testing.T, credentials *testCredentials) {
+	select {
+	case started := <-credentials.started:
+		t.Fatalf("mapper was restarted with certificate %s", started)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_runWithCredentialReload(t *testing.T) {
+	options := &VirtualClusterOptions{CredentialReloadInterval: 10 * time.Millisecond}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	t.Cleanup(func() { deleteVClusterMetrics(vClusterKey(options)) })
+
+	credentials := &testCredentials{cert: "cert", started: make(chan string, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- runWithCredentialReload(ctx, options, credentials.load, credentials.run)
+	}()
+
+	expectStarted(t, credentials, "cert")
+	expectNotStarted(t, credentials)
+
+	// the mapper keeps running while the credentials can't be loaded
+	credentials.rotate("", errors.New("secret not found"))
+	expectNotStarted(t, credentials)
+
+	credentials.rotate("rotated", nil)
+	expectStarted(t, credentials, "rotated")
+	expectNotStarted(t, credentials)
+
+	cancel()
+	assert.NilError(t, <-result)
+}
+
+func Test_runWithCredentialReloadWait(t *testing.T) {
+	options := &VirtualClusterOptions{CredentialReloadInterval: 10 * time.Millisecond}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+
+	// the secret of the vCluster is created after the start of the mapper
+	credentials := &testCredentials{loadErr: errors.New("secret not found"), started: make(chan string, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- runWithCredentialReload(ctx, options, credentials.load, credentials.run)
+	}()
+
+	expectNotStarted(t, credentials)
+	credentials.rotate("cert", nil)
+	expectStarted(t, credentials, "cert")
+
+	cancel()
+	assert.NilError(t, <-result)
+}
+
+func Test_runWithCredentialReloadError(t *testing.T) {
+	waitTimeout := credentialWaitTimeout
+	credentialWaitTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { credentialWaitTimeout = waitTimeout })
+
+	options := &VirtualClusterOptions{CredentialReloadInterval: 10 * time.Millisecond}
+	loadErr := errors.New("secret not found")
+	credentials := &testCredentials{loadErr: loadErr}
+	err := runWithCredentialReload(context.Background(), options, credentials.load, credentials.run)
+	assert.Equal(t, err, loadErr)
+
+	runErr := errors.New("unauthorized")
+	credentials = &testCredentials{cert: "cert"}
+	err = runWithCredentialReload(context.Background(), options, credentials.load, func(context.Context, *rest.Config) error {
+		return runErr
+	})
+	assert.Equal(t, err, runErr)
+}
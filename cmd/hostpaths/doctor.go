@@ -0,0 +1,524 @@
+package hostpaths
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/loft-sh/vcluster/pkg/util/clienthelper"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	CheckStatusPass = "pass"
+	CheckStatusWarn = "warn"
+	CheckStatusFail = "fail"
+	CheckStatusSkip = "skip"
+
+	// certificateExpiryWarning is how long before their expiry certificates
+	// are reported
+	certificateExpiryWarning = 7 * 24 * time.Hour
+
+	// doctorAPITimeout bounds the requests to the api servers
+	doctorAPITimeout = 10 * time.Second
+
+	mountInfoPath = "/proc/self/mountinfo"
+
+	MountPropagationShared  = "shared"
+	MountPropagationSlave   = "slave"
+	MountPropagationPrivate = "private"
+)
+
+// doctorReport is the outcome of the checks of a node's environment
+type doctorReport struct {
+	VCluster string        `json:"vcluster"`
+	Node     string        `json:"node"`
+	Checks   []checkResult `json:"checks"`
+}
+
+type checkResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+
+	// Hint tells how to fix a failed check
+	Hint string `json:"hint,omitempty"`
+}
+
+func NewDoctorCommand() *cobra.Command {
+	options := &VirtualClusterOptions{}
+	nodeName := ""
+	output := OutputTable
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the environment of the current node for host path mapping",
+		Long: `Checks the prerequisites of mapping the host paths of a virtual cluster
+on the node: the vcluster config, the connection to both api servers, the
+certificates, the mounted host paths and their mount propagation. Prints a
+report with hints and fails if any check failed. Needs to be run within the
+hostpath mapper pod, e.g. via kubectl exec.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			if output != OutputTable && output != OutputJSON {
+				return fmt.Errorf("unsupported output %q, use %s or %s", output, OutputTable, OutputJSON)
+			}
+
+			err := validateConnectionOptions(options)
+			if err != nil {
+				return err
+			}
+
+			err = setupHostLayout(cobraCmd.Flags(), options)
+			if err != nil {
+				return err
+			}
+
+			report, err := runDoctor(cobraCmd.Context(), options, nodeName)
+			if err != nil {
+				return err
+			}
+
+			err = printDoctorReport(cobraCmd.OutOrStdout(), report, output)
+			if err != nil {
+				return err
+			}
+
+			if failed := report.failed(); failed > 0 {
+				return fmt.Errorf("%d of %d checks failed", failed, len(report.Checks))
+			}
+
+			return nil
+		},
+	}
+
+	addVirtualClusterFlags(cmd.Flags(), options)
+	addHostLayoutFlags(cmd.Flags(), options)
+	cmd.Flags().BoolVar(&options.Central, "central", false, "If the virtual cluster is mapped by the central hostpath mapper")
+	cmd.Flags().StringVar(&nodeName, "node", os.Getenv(HostpathMapperSelfNodeNameEnvVar), "The node the hostpath mapper runs on, defaults to the node of the mapper pod")
+	cmd.Flags().StringVarP(&output, "output", "o", OutputTable, "The output format, table or json")
+
+	return cmd
+}
+
+func (r *doctorReport) add(result checkResult) {
+	r.Checks = append(r.Checks, result)
+}
+
+func (r *doctorReport) failed() int {
+	failed := 0
+	for _, check := range r.Checks {
+		if check.Status == CheckStatusFail {
+			failed++
+		}
+	}
+
+	return failed
+}
+
+// runDoctor runs all checks, checks whose prerequisites failed are skipped
+func runDoctor(ctx context.Context, options *VirtualClusterOptions, nodeName string) (*doctorReport, error) {
+	if options.TargetNamespace == "" {
+		currentNamespace, err := clienthelper.CurrentNamespace()
+		if err != nil {
+			return nil, err
+		}
+
+		options.TargetNamespace = currentNamespace
+	}
+
+	setVirtualPaths(options)
+	report := &doctorReport{
+		VCluster: vClusterKey(options),
+		Node:     nodeName,
+	}
+
+	if nodeName == "" {
+		report.add(checkResult{Name: "node-name", Status: CheckStatusFail, Message: "node name is empty", Hint: fmt.Sprintf("set %s to spec.nodeName through the downward api or pass --node", HostpathMapperSelfNodeNameEnvVar)})
+	} else {
+		report.add(checkResult{Name: "node-name", Status: CheckStatusPass, Message: fmt.Sprintf("running on node %s", nodeName)})
+	}
+
+	kubeClient, result := checkHostAPI()
+	report.add(result)
+
+	if kubeClient == nil {
+		report.add(checkResult{Name: "vcluster-config", Status: CheckStatusSkip, Message: "host api server is not reachable"})
+	} else {
+		report.add(checkVClusterConfig(ctx, kubeClient, options))
+	}
+
+	var virtualClusterConfig *rest.Config
+	if options.Central {
+		if kubeClient == nil {
+			virtualClusterConfig = nil
+			report.add(checkResult{Name: "certificates", Status: CheckStatusSkip, Message: "host api server is not reachable"})
+		} else {
+			var err error
+			virtualClusterConfig, err = getCentralVirtualClusterConfig(ctx, kubeClient, options)
+			if err != nil {
+				report.add(checkResult{Name: "certificates", Status: CheckStatusFail, Message: err.Error(), Hint: "the vcluster secret vc-<name> is created by the vcluster, check that it is running"})
+			} else {
+				report.add(checkCertificate("client-certificate", virtualClusterConfig.CertData, time.Now()))
+				report.add(checkCertificate("certificate-authority", virtualClusterConfig.CAData, time.Now()))
+			}
+		}
+	} else if options.VirtualKubeConfig != "" || options.VirtualKubeConfigSecret != "" {
+		var result checkResult
+		virtualClusterConfig, result = checkVirtualKubeConfig(ctx, kubeClient, options)
+		report.add(result)
+		if virtualClusterConfig != nil && len(virtualClusterConfig.CertData) > 0 {
+			report.add(checkCertificate("client-certificate", virtualClusterConfig.CertData, time.Now()))
+		}
+	} else {
+		virtualClusterConfig = newVirtualClusterConfig(options)
+		if options.VirtualServer != "" {
+			virtualClusterConfig.Host = options.VirtualServer
+		}
+
+		report.add(checkCertificateFile("client-certificate", options.ClientCaCert, time.Now()))
+		report.add(checkCertificateFile("certificate-authority", options.ServerCaCert, time.Now()))
+		report.add(checkReadable("client-key", options.ServerCaKey, "mount the vc-<name> secret of the vcluster to /data/server/tls"))
+	}
+
+	if virtualClusterConfig == nil {
+		report.add(checkResult{Name: "virtual-api", Status: CheckStatusSkip, Message: "no virtual cluster credentials"})
+	} else {
+		report.add(checkVirtualAPI(virtualClusterConfig))
+	}
+
+	layout := options.layout()
+	containerLogs := checkDirectory("container-logs", layout.containerLogsPath, false,
+		"the container runtime of the node does not link the container logs in /var/log/containers, which the container log links of the virtual pods are based on, mount the host path /var/log into the mapper")
+	if containerLogs.Status == CheckStatusFail {
+		// the mapper runs without the container log links
+		containerLogs.Status = CheckStatusWarn
+	}
+	report.add(containerLogs)
+	report.add(checkDirectory("pod-logs", layout.podLogsPath, false, "mount the host path /var/log/pods to "+layout.podLogsPath))
+	report.add(checkDirectory("physical-kubelet-pods", layout.kubeletPodsPath, false, "mount the host path /var/lib/kubelet/pods to "+layout.kubeletPodsPath))
+	for _, path := range []string{options.VirtualPodLogsPath, options.VirtualContainerLogsPath, options.VirtualKubeletPodPath} {
+		report.add(checkDirectory("virtual-path "+path, path, true, "mount the host path "+path+" into the mapper, it needs to be the same path the vcluster mounts into its pods"))
+	}
+
+	report.add(checkMountPropagation(layout.kubeletPodsPath))
+	return report, nil
+}
+
+func checkHostAPI() (kubernetes.Interface, checkResult) {
+	result := checkResult{Name: "host-api", Hint: "the service account token of the mapper pod needs to be mounted and the kubernetes service reachable"}
+
+	inClusterConfig, err := ctrl.GetConfig()
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = fmt.Sprintf("get in cluster config: %v", err)
+		return nil, result
+	}
+
+	inClusterConfig = rest.CopyConfig(inClusterConfig)
+	inClusterConfig.Timeout = doctorAPITimeout
+	kubeClient, err := kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = fmt.Sprintf("create kube client: %v", err)
+		return nil, result
+	}
+
+	version, err := kubeClient.Discovery().ServerVersion()
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = fmt.Sprintf("host api server %s is not reachable: %v", inClusterConfig.Host, err)
+		return nil, result
+	}
+
+	result.Status = CheckStatusPass
+	result.Message = fmt.Sprintf("connected to host api server %s (%s)", inClusterConfig.Host, version.GitVersion)
+	result.Hint = ""
+	return kubeClient, result
+}
+
+func checkVClusterConfig(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) checkResult {
+	result := checkResult{Name: "vcluster-config", Status: CheckStatusFail}
+	secretName := fmt.Sprintf(configSecretNameTemplate, options.Name)
+
+	vClusterConfig, err := getVclusterConfigFromSecret(ctx, kubeClient, options.Name, options.TargetNamespace)
+	if kerrors.IsNotFound(err) {
+		result.Message = fmt.Sprintf("config secret %s/%s not found", options.TargetNamespace, secretName)
+		result.Hint = "check --name and --target-namespace, the secret is created by vcluster v0.20 and newer"
+		return result
+	} else if err != nil {
+		result.Message = fmt.Sprintf("read config secret %s/%s: %v", options.TargetNamespace, secretName, err)
+		return result
+	}
+
+	hostPathMapper := vClusterConfig.ControlPlane.HostPathMapper
+	switch {
+	case !hostPathMapper.Enabled:
+		result.Message = "the hostpath mapper is not enabled in the vcluster config"
+		result.Hint = "deploy the vcluster with controlPlane.hostPathMapper.enabled=true, so it rewrites the host paths of its pods"
+		return result
+	case options.Central && !hostPathMapper.Central:
+		result.Message = "the vcluster does not use the central hostpath mapper"
+		result.Hint = "deploy the vcluster with controlPlane.hostPathMapper.central=true or deploy a hostpath mapper for it"
+		return result
+	case !options.Central && hostPathMapper.Central:
+		result.Message = "the vcluster uses the central hostpath mapper"
+		result.Hint = "run the doctor with --central in the central hostpath mapper, a hostpath mapper per vcluster is not needed"
+		return result
+	}
+
+	_, err = newTranslator(options, vClusterConfig)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+
+	result.Status = CheckStatusPass
+	result.Message = fmt.Sprintf("hostpath mapper is enabled in config secret %s/%s", options.TargetNamespace, secretName)
+	return result
+}
+
+// checkVirtualKubeConfig loads the kubeconfig of the virtual cluster from the
+// file or secret
+func checkVirtualKubeConfig(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) (*rest.Config, checkResult) {
+	result := checkResult{Name: "virtual-kubeconfig"}
+	if options.VirtualKubeConfigSecret != "" && kubeClient == nil {
+		result.Status = CheckStatusSkip
+		result.Message = "host api server is not reachable"
+		return nil, result
+	}
+
+	virtualClusterConfig, err := getVirtualClusterConfig(ctx, kubeClient, options)
+	if err != nil {
+		result.Status = CheckStatusFail
+		result.Message = err.Error()
+		result.Hint = "check --virtual-kubeconfig or --virtual-kubeconfig-secret, the secret needs the kubeconfig in its config key"
+		return nil, result
+	}
+
+	result.Status = CheckStatusPass
+	result.Message = fmt.Sprintf("loaded kubeconfig for virtual api server %s", virtualClusterConfig.Host)
+	return virtualClusterConfig, result
+}
+
+func checkVirtualAPI(virtualClusterConfig *rest.Config) checkResult {
+	result := checkResult{Name: "virtual-api", Status: CheckStatusFail}
+
+	virtualClusterConfig = rest.CopyConfig(virtualClusterConfig)
+	virtualClusterConfig.Timeout = doctorAPITimeout
+	virtualClient, err := kubernetes.NewForConfig(virtualClusterConfig)
+	if err != nil {
+		result.Message = fmt.Sprintf("create virtual kube client: %v", err)
+		return result
+	}
+
+	version, err := virtualClient.Discovery().ServerVersion()
+	if err != nil {
+		result.Message = fmt.Sprintf("virtual api server %s is not reachable: %v", virtualClusterConfig.Host, err)
+		result.Hint = "check that the vcluster is running and not sleeping, and that its service is reachable from the node"
+		return result
+	}
+
+	result.Status = CheckStatusPass
+	result.Message = fmt.Sprintf("connected to virtual api server %s (%s)", virtualClusterConfig.Host, version.GitVersion)
+	return result
+}
+
+func checkCertificateFile(name, path string, now time.Time) checkResult {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkResult{Name: name, Status: CheckStatusFail, Message: fmt.Sprintf("read %s: %v", path, err), Hint: "mount the vc-<name> secret of the vcluster to /data/server/tls"}
+	}
+
+	result := checkCertificate(name, data, now)
+	result.Message = path + ": " + result.Message
+	return result
+}
+
+// checkCertificate checks that all certificates in the PEM data are valid
+// and reports the earliest expiry
+func checkCertificate(name string, data []byte, now time.Time) checkResult {
+	result := checkResult{Name: name, Status: CheckStatusFail, Hint: "the certificates are rotated by the vcluster, the hostpath mapper reconnects once the vc-<name> secret was updated"}
+
+	var expiry time.Time
+	for block, remaining := pem.Decode(data); block != nil; block, remaining = pem.Decode(remaining) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			result.Message = fmt.Sprintf("parse certificate: %v", err)
+			return result
+		} else if now.Before(cert.NotBefore) {
+			result.Message = fmt.Sprintf("certificate %s is not valid before %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+			result.Hint = "check the clock of the node"
+			return result
+		} else if now.After(cert.NotAfter) {
+			result.Message = fmt.Sprintf("certificate %s expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+			return result
+		}
+
+		if expiry.IsZero() || cert.NotAfter.Before(expiry) {
+			expiry = cert.NotAfter
+		}
+	}
+
+	if expiry.IsZero() {
+		result.Message = "no certificate found"
+		result.Hint = "mount the vc-<name> secret of the vcluster to /data/server/tls"
+		return result
+	}
+
+	result.Message = fmt.Sprintf("valid until %s", expiry.Format(time.RFC3339))
+	if expiry.Sub(now) < certificateExpiryWarning {
+		result.Status = CheckStatusWarn
+		return result
+	}
+
+	result.Status = CheckStatusPass
+	result.Hint = ""
+	return result
+}
+
+func checkReadable(name, path, hint string) checkResult {
+	_, err := os.ReadFile(path)
+	if err != nil {
+		return checkResult{Name: name, Status: CheckStatusFail, Message: fmt.Sprintf("read %s: %v", path, err), Hint: hint}
+	}
+
+	return checkResult{Name: name, Status: CheckStatusPass, Message: fmt.Sprintf("%s is readable", path)}
+}
+
+// checkDirectory checks that path is a directory and if the mapper needs to
+// create links in it, that it is writable
+func checkDirectory(name, path string, writable bool, hint string) checkResult {
+	result := checkResult{Name: name, Status: CheckStatusFail, Hint: hint}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		result.Message = fmt.Sprintf("%s does not exist: %v", path, err)
+		return result
+	} else if !info.IsDir() {
+		result.Message = fmt.Sprintf("%s is no directory", path)
+		return result
+	}
+
+	if writable {
+		file, err := os.CreateTemp(path, ".hostpath-mapper-doctor-")
+		if err != nil {
+			result.Message = fmt.Sprintf("%s is not writable: %v", path, err)
+			result.Hint = "the host path needs to be mounted read-write"
+			return result
+		}
+
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}
+
+	result.Status = CheckStatusPass
+	result.Message = fmt.Sprintf("%s exists", path)
+	result.Hint = ""
+	return result
+}
+
+// checkMountPropagation checks that volumes the kubelet mounts after the start
+// of the mapper are visible within path
+func checkMountPropagation(path string) checkResult {
+	result := checkResult{Name: "mount-propagation", Status: CheckStatusWarn}
+
+	mountInfo, err := os.Open(mountInfoPath)
+	if err != nil {
+		result.Message = fmt.Sprintf("read %s: %v", mountInfoPath, err)
+		return result
+	}
+	defer mountInfo.Close()
+
+	propagation, ok := mountPropagation(mountInfo, path)
+	switch {
+	case !ok:
+		result.Message = fmt.Sprintf("%s is not a mount point", path)
+		result.Hint = "mount the host path /var/lib/kubelet/pods to " + path
+	case propagation == MountPropagationPrivate:
+		result.Message = fmt.Sprintf("%s is mounted with private propagation, volumes mounted after the start of the mapper are not visible through the kubelet links", path)
+		result.Hint = "set mountPropagation: HostToContainer on the volume mount"
+	default:
+		result.Status = CheckStatusPass
+		result.Message = fmt.Sprintf("%s is mounted with %s propagation", path, propagation)
+	}
+
+	return result
+}
+
+// mountPropagation returns the propagation of the mount at path as listed in
+// the mountinfo format, see proc(5)
+func mountPropagation(mountInfo io.Reader, path string) (string, bool) {
+	propagation, found := "", false
+
+	scanner := bufio.NewScanner(mountInfo)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 || fields[4] != path {
+			continue
+		}
+
+		// later mounts on the same path hide the earlier ones
+		propagation, found = MountPropagationPrivate, true
+		for _, field := range fields[6:] {
+			if field == "-" {
+				break
+			} else if strings.HasPrefix(field, "shared:") {
+				propagation = MountPropagationShared
+			} else if strings.HasPrefix(field, "master:") && propagation != MountPropagationShared {
+				propagation = MountPropagationSlave
+			}
+		}
+	}
+
+	return propagation, found
+}
+
+func printDoctorReport(w io.Writer, report *doctorReport, output string) error {
+	if output == OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "vCluster %s on node %s\n\n", report.VCluster, report.Node)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tMESSAGE")
+	for _, check := range report.Checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Name, check.Status, check.Message)
+	}
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	hints := []string{}
+	for _, check := range report.Checks {
+		if check.Hint != "" && (check.Status == CheckStatusFail || check.Status == CheckStatusWarn) {
+			hints = append(hints, fmt.Sprintf("  %s: %s", check.Name, check.Hint))
+		}
+	}
+	if len(hints) > 0 {
+		fmt.Fprintf(w, "\nHints:\n%s\n", strings.Join(hints, "\n"))
+	}
+
+	return nil
+}
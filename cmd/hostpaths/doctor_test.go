@@ -0,0 +1,124 @@
+package hostpaths
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func Test_checkCertificate(t *testing.T) {
+	now := time.Now()
+	newCertificate := func(notBefore, notAfter time.Time) []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NilError(t, err)
+
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "vcluster"},
+			NotBefore:    notBefore,
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		assert.NilError(t, err)
+
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+
+	valid := newCertificate(now.Add(-time.Hour), now.Add(365*24*time.Hour))
+	expiringSoon := newCertificate(now.Add(-time.Hour), now.Add(24*time.Hour))
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		status string
+	}{
+		{name: "valid", data: valid, status: CheckStatusPass},
+		{name: "expiring soon", data: expiringSoon, status: CheckStatusWarn},
+		{name: "chain with an expiring certificate", data: append(append([]byte{}, valid...), expiringSoon...), status: CheckStatusWarn},
+		{name: "expired", data: newCertificate(now.Add(-2*time.Hour), now.Add(-time.Hour)), status: CheckStatusFail},
+		{name: "not yet valid", data: newCertificate(now.Add(time.Hour), now.Add(2*time.Hour)), status: CheckStatusFail},
+		{name: "no certificate", data: []byte("garbage"), status: CheckStatusFail},
+	}
+
+	for _, testCase := range testCases {
+		result := checkCertificate("client-certificate", testCase.data, now)
+		assert.Equal(t, result.Status, testCase.status, "%s: %s", testCase.name, result.Message)
+	}
+}
+
+func Test_checkDirectory(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	assert.NilError(t, os.WriteFile(file, nil, 0600))
+
+	assert.Equal(t, checkDirectory("dir", dir, true, "").Status, CheckStatusPass)
+	assert.Equal(t, checkDirectory("file", file, false, "").Status, CheckStatusFail)
+	assert.Equal(t, checkDirectory("missing", filepath.Join(dir, "missing"), false, "").Status, CheckStatusFail)
+
+	// the writability check leaves nothing behind
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+}
+
+func Test_mountPropagation(t *testing.T) {
+	mountInfo := `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+600 580 8:1 /var/lib/kubelet/pods /var/vcluster/physical/kubelet/pods rw,relatime master:1 - ext4 /dev/sda1 rw
+601 580 8:1 /var/log/pods /var/log/pods rw,relatime - ext4 /dev/sda1 rw
+602 580 8:1 /tmp/vcluster /tmp/vcluster rw,relatime - ext4 /dev/sda1 rw
+603 580 8:1 /tmp/vcluster /tmp/vcluster rw,relatime shared:5 master:1 - ext4 /dev/sda1 rw
+`
+
+	testCases := []struct {
+		path        string
+		propagation string
+		found       bool
+	}{
+		{path: "/var/vcluster/physical/kubelet/pods", propagation: MountPropagationSlave, found: true},
+		{path: "/var/log/pods", propagation: MountPropagationPrivate, found: true},
+		{path: "/tmp/vcluster", propagation: MountPropagationShared, found: true},
+		{path: "/var/log", found: false},
+	}
+
+	for _, testCase := range testCases {
+		propagation, found := mountPropagation(strings.NewReader(mountInfo), testCase.path)
+		assert.Equal(t, found, testCase.found, testCase.path)
+		assert.Equal(t, propagation, testCase.propagation, testCase.path)
+	}
+}
+
+func Test_printDoctorReport(t *testing.T) {
+	report := &doctorReport{
+		VCluster: "vcluster-ns/vcluster",
+		Node:     "node-a",
+		Checks: []checkResult{
+			{Name: "node-name", Status: CheckStatusPass, Message: "running on node node-a"},
+			{Name: "container-logs", Status: CheckStatusFail, Message: "/var/log/containers does not exist", Hint: "mount the host path /var/log into the mapper"},
+		},
+	}
+	assert.Equal(t, report.failed(), 1)
+
+	output := &bytes.Buffer{}
+	assert.NilError(t, printDoctorReport(output, report, OutputTable))
+	assert.Equal(t, output.String(), `vCluster vcluster-ns/vcluster on node node-a
+
+CHECK           STATUS  MESSAGE
+node-name       pass    running on node node-a
+container-logs  fail    /var/log/containers does not exist
+
+Hints:
+  container-logs: mount the host path /var/log into the mapper
+`)
+}
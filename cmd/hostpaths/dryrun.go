@@ -0,0 +1,69 @@
+package hostpaths
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	DryRunOutputLog  = "log"
+	DryRunOutputJSON = "json"
+
+	dryRunActionCreateSymlink   = "create-symlink"
+	dryRunActionReplaceSymlink  = "replace-symlink"
+	dryRunActionCreateDirectory = "create-directory"
+	dryRunActionDelete          = "delete"
+	dryRunActionRestartPod      = "restart-pod"
+)
+
+var (
+	// dryRunOutput receives the planned actions if they are emitted as JSON
+	dryRunOutput   io.Writer = os.Stdout
+	dryRunOutputMu sync.Mutex
+)
+
+// dryRunAction is a change the mapper would have made to the host
+type dryRunAction struct {
+	VCluster string `json:"vcluster"`
+	Action   string `json:"action"`
+	Kind     string `json:"kind,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Pod      string `json:"pod,omitempty"`
+}
+
+// reportDryRun logs the action or writes it as a JSON line to stdout
+func reportDryRun(options *VirtualClusterOptions, action dryRunAction) {
+	if action.VCluster == "" {
+		action.VCluster = vClusterKey(options)
+	}
+
+	if options.DryRunOutput == DryRunOutputJSON {
+		dryRunOutputMu.Lock()
+		defer dryRunOutputMu.Unlock()
+
+		err := json.NewEncoder(dryRunOutput).Encode(action)
+		if err != nil {
+			klog.Errorf("error writing dry run action: %v", err)
+		}
+
+		return
+	}
+
+	switch action.Action {
+	case dryRunActionCreateSymlink:
+		klog.Infof("dry run: would create %s symlink %s -> %s", action.Kind, action.Path, action.Target)
+	case dryRunActionReplaceSymlink:
+		klog.Infof("dry run: would replace %s symlink %s -> %s", action.Kind, action.Path, action.Target)
+	case dryRunActionCreateDirectory:
+		klog.Infof("dry run: would create directory %s", action.Path)
+	case dryRunActionDelete:
+		klog.Infof("dry run: would delete %s", action.Path)
+	case dryRunActionRestartPod:
+		klog.Infof("dry run: would restart pod %s", action.Pod)
+	}
+}
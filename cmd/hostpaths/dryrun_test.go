@@ -0,0 +1,64 @@
+package hostpaths
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func Test_dryRun(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{DryRun: true, DryRunOutput: DryRunOutputJSON}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+
+	output := &bytes.Buffer{}
+	dryRunOutput = output
+	defer func() { dryRunOutput = os.Stdout }()
+
+	existing := filepath.Join(dir, "existing")
+	assert.NilError(t, os.Symlink(dir, existing))
+	wrongTarget := filepath.Join(dir, "wrong")
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "old"), wrongTarget))
+
+	source := filepath.Join(dir, "new")
+	created, err := ensureSymlink(ctx, SymlinkKindPodLog, dir, source)
+	assert.NilError(t, err)
+	assert.Assert(t, created)
+	created, err = ensureSymlink(ctx, SymlinkKindPodLog, dir, existing)
+	assert.NilError(t, err)
+	assert.Assert(t, !created)
+	_, err = ensureSymlink(ctx, SymlinkKindPodLog, dir, wrongTarget)
+	assert.NilError(t, err)
+	assert.NilError(t, removePath(ctx, SymlinkKindPodLog, existing))
+
+	_, err = os.Lstat(source)
+	assert.Assert(t, os.IsNotExist(err), "dry run created %s", source)
+	_, err = os.Lstat(existing)
+	assert.NilError(t, err, "dry run deleted %s", existing)
+	target, err := os.Readlink(wrongTarget)
+	assert.NilError(t, err)
+	assert.Equal(t, target, filepath.Join(dir, "old"), "dry run replaced %s", wrongTarget)
+
+	expectedActions := []dryRunAction{
+		{VCluster: "vcluster-ns/vcluster", Action: dryRunActionCreateSymlink, Kind: SymlinkKindPodLog, Path: source, Target: dir},
+		{VCluster: "vcluster-ns/vcluster", Action: dryRunActionReplaceSymlink, Kind: SymlinkKindPodLog, Path: wrongTarget, Target: dir},
+		{VCluster: "vcluster-ns/vcluster", Action: dryRunActionDelete, Kind: SymlinkKindPodLog, Path: existing},
+	}
+
+	actions := []dryRunAction{}
+	decoder := json.NewDecoder(output)
+	for decoder.More() {
+		action := dryRunAction{}
+		assert.NilError(t, decoder.Decode(&action))
+		actions = append(actions, action)
+	}
+
+	assert.DeepEqual(t, actions, expectedActions)
+}
@@ -0,0 +1,92 @@
+package hostpaths
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	EventReasonMapped               = "HostPathsMapped"
+	EventReasonPhysicalPodNotFound  = "PhysicalPodNotFound"
+	EventReasonLogDirectoryNotFound = "PodLogDirectoryNotFound"
+	EventReasonContainerLogNotFound = "ContainerLogNotFound"
+	EventReasonMappingFailed        = "HostPathMappingFailed"
+)
+
+// podEvents records the mapping outcome as events on the virtual pods, so it
+// shows up in kubectl describe within the vCluster. Only changes of the
+// outcome of a pod are recorded, as pods are mapped again on every resync.
+type podEvents struct {
+	options  *VirtualClusterOptions
+	recorder record.EventRecorder
+
+	m sync.Mutex
+	// last is the last recorded reason and message per virtual pod
+	last map[types.UID]string
+}
+
+func newPodEvents(options *VirtualClusterOptions, recorder record.EventRecorder) *podEvents {
+	return &podEvents{
+		options:  options,
+		recorder: recorder,
+		last:     map[types.UID]string{},
+	}
+}
+
+func (e *podEvents) mapped(vPod *corev1.Pod, mapping *podMapping) {
+	if len(mapping.missingContainerLogs) > 0 {
+		e.record(vPod, corev1.EventTypeWarning, EventReasonContainerLogNotFound,
+			"Logs of container(s) %s are not mapped, their entries in /var/log/containers do not exist", strings.Join(mapping.missingContainerLogs, ", "))
+		return
+	}
+
+	pPod := mapping.physicalPod
+	e.record(vPod, corev1.EventTypeNormal, EventReasonMapped, "Mapped the logs and kubelet paths of the pod to physical pod %s/%s", pPod.Namespace, pPod.Name)
+}
+
+func (e *podEvents) physicalPodNotFound(vPod *corev1.Pod) {
+	e.record(vPod, corev1.EventTypeNormal, EventReasonPhysicalPodNotFound, "Physical pod not found yet, the logs of the pod are mapped once it exists")
+}
+
+func (e *podEvents) logDirectoryNotFound(vPod *corev1.Pod, pPod *corev1.Pod) {
+	e.record(vPod, corev1.EventTypeNormal, EventReasonLogDirectoryNotFound, "Log directory of physical pod %s/%s does not exist yet", pPod.Namespace, pPod.Name)
+}
+
+func (e *podEvents) failed(vPod *corev1.Pod, err error) {
+	e.record(vPod, corev1.EventTypeWarning, EventReasonMappingFailed, "Mapping the host paths of the pod failed: %v", err)
+}
+
+func (e *podEvents) record(vPod *corev1.Pod, eventType, reason, messageFmt string, args ...interface{}) {
+	// events are changes to the virtual cluster, which a dry run must not make
+	if e.recorder == nil || e.options.DryRun {
+		return
+	}
+
+	outcome := reason + ": " + fmt.Sprintf(messageFmt, args...)
+
+	e.m.Lock()
+	changed := e.last[vPod.UID] != outcome
+	e.last[vPod.UID] = outcome
+	e.m.Unlock()
+
+	if changed {
+		e.recorder.Eventf(vPod, eventType, reason, messageFmt, args...)
+	}
+}
+
+// prune forgets the outcome of virtual pods that no longer exist
+func (e *podEvents) prune(existing map[types.UID]bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+
+	for uid := range e.last {
+		if !existing[uid] {
+			delete(e.last, uid)
+		}
+	}
+}
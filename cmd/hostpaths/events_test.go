@@ -0,0 +1,59 @@
+package hostpaths
+
+import (
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_podEvents(t *testing.T) {
+	options := &VirtualClusterOptions{}
+	recorder := record.NewFakeRecorder(10)
+	podEvents := newPodEvents(options, recorder)
+
+	vPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "uid-a"}}
+	pPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app-x-default-x-vcluster", Namespace: "vcluster-ns"}}
+	mapping := &podMapping{physicalPod: pPod}
+
+	expectEvents := func(expected ...string) {
+		t.Helper()
+		var events []string
+		for len(recorder.Events) > 0 {
+			events = append(events, <-recorder.Events)
+		}
+		assert.DeepEqual(t, events, expected)
+	}
+
+	podEvents.physicalPodNotFound(vPod)
+	podEvents.physicalPodNotFound(vPod)
+	podEvents.logDirectoryNotFound(vPod, pPod)
+	expectEvents(
+		"Normal PhysicalPodNotFound Physical pod not found yet, the logs of the pod are mapped once it exists",
+		"Normal PodLogDirectoryNotFound Log directory of physical pod vcluster-ns/app-x-default-x-vcluster does not exist yet",
+	)
+
+	// the mapping is only reported again once its outcome changes
+	podEvents.mapped(vPod, mapping)
+	podEvents.mapped(vPod, mapping)
+	mapping.missingContainerLogs = []string{"app", "sidecar"}
+	podEvents.mapped(vPod, mapping)
+	podEvents.failed(vPod, errors.New("permission denied"))
+	expectEvents(
+		"Normal HostPathsMapped Mapped the logs and kubelet paths of the pod to physical pod vcluster-ns/app-x-default-x-vcluster",
+		"Warning ContainerLogNotFound Logs of container(s) app, sidecar are not mapped, their entries in /var/log/containers do not exist",
+		"Warning HostPathMappingFailed Mapping the host paths of the pod failed: permission denied",
+	)
+
+	podEvents.prune(map[types.UID]bool{})
+	assert.Equal(t, len(podEvents.last), 0)
+
+	// dry runs do not create events
+	options.DryRun = true
+	podEvents.physicalPodNotFound(vPod)
+	expectEvents()
+}
@@ -0,0 +1,96 @@
+package hostpaths
+
+import (
+	"os"
+	"path/filepath"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+)
+
+// hostFS is the filesystem the mapper reads the physical paths from and
+// maintains the virtual paths in
+type hostFS interface {
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	ReadDir(name string) ([]os.DirEntry, error)
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+}
+
+// osFS is the filesystem of the mapper container
+type osFS struct{}
+
+func (osFS) Symlink(oldname, newname string) error        { return os.Symlink(oldname, newname) }
+func (osFS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (osFS) ReadDir(name string) ([]os.DirEntry, error)   { return os.ReadDir(name) }
+func (osFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
+func (osFS) Lstat(name string) (os.FileInfo, error)       { return os.Lstat(name) }
+func (osFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+func (osFS) Remove(name string) error                     { return os.Remove(name) }
+func (osFS) RemoveAll(path string) error                  { return os.RemoveAll(path) }
+func (osFS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (osFS) ReadFile(name string) ([]byte, error)         { return os.ReadFile(name) }
+func (osFS) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+// hostLayout is where the mapper finds the physical paths of the node. The
+// symlinks it creates still point to the paths the pods of the vCluster see,
+// which are the same as the roots of the default layout.
+type hostLayout struct {
+	fs hostFS
+
+	// containerLogsPath, podLogsPath and kubeletPodsPath are the paths the
+	// node's /var/log/containers, /var/log/pods and /var/lib/kubelet/pods
+	// are mounted to
+	containerLogsPath string
+	podLogsPath       string
+	kubeletPodsPath   string
+
+	// noContainerLogs is set if the container logs path does not exist,
+	// e.g. as the container runtime doesn't link the logs there, no
+	// container log links are created then
+	noContainerLogs bool
+
+	// virtualPath contains the virtual paths of every vCluster in a
+	// <namespace>/<name> directory
+	virtualPath string
+}
+
+func newDefaultHostLayout() *hostLayout {
+	return &hostLayout{
+		fs:                osFS{},
+		containerLogsPath: ContainerLogsMountPath,
+		podLogsPath:       PodLogsMountPath,
+		kubeletPodsPath:   podtranslate.PhysicalKubeletVolumeMountPath,
+		virtualPath:       VirtualPathMountPath,
+	}
+}
+
+// vClusterPath returns the root of the virtual paths of a vCluster
+func (l *hostLayout) vClusterPath(namespace, name string) string {
+	return filepath.Join(l.virtualPath, namespace, name)
+}
+
+var defaultHostLayout = newDefaultHostLayout()
+
+// layout returns the host layout of the options, which defaults to the
+// paths the hostpath mapper chart mounts
+func (o *VirtualClusterOptions) layout() *hostLayout {
+	if o.host == nil {
+		return defaultHostLayout
+	}
+
+	return o.host
+}
+
+// fs is a shorthand for the filesystem of the host layout
+func (o *VirtualClusterOptions) fs() hostFS {
+	return o.layout().fs
+}
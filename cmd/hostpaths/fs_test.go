@@ -0,0 +1,180 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// testHost is a node in a temp dir, with the physical paths kubelet
+// maintains and the virtual paths the mapper maintains
+type testHost struct {
+	t       *testing.T
+	ctx     context.Context
+	options *VirtualClusterOptions
+
+	// virtualPath contains the virtual paths of the options
+	virtualPath string
+}
+
+func newTestHost(t *testing.T) *testHost {
+	dir := t.TempDir()
+	physicalPath := filepath.Join(dir, "physical")
+	virtualPath := filepath.Join(dir, "virtual")
+
+	options := &VirtualClusterOptions{
+		VirtualRootPath:          virtualPath,
+		VirtualLogsPath:          filepath.Join(virtualPath, "log"),
+		VirtualPodLogsPath:       filepath.Join(virtualPath, "log", "pods"),
+		VirtualContainerLogsPath: filepath.Join(virtualPath, "log", "containers"),
+		VirtualKubeletPodPath:    filepath.Join(virtualPath, "kubelet", "pods"),
+		RetentionPeriod:          time.Hour,
+		host: &hostLayout{
+			fs:                osFS{},
+			containerLogsPath: filepath.Join(physicalPath, "log", "containers"),
+			podLogsPath:       filepath.Join(physicalPath, "log", "pods"),
+			kubeletPodsPath:   filepath.Join(physicalPath, "kubelet", "pods"),
+		},
+	}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	options.translator = newSingleNamespaceTranslator("vcluster", "vcluster-ns")
+
+	for _, path := range []string{
+		options.VirtualPodLogsPath,
+		options.VirtualContainerLogsPath,
+		options.VirtualKubeletPodPath,
+		options.host.podLogsPath,
+		options.host.containerLogsPath,
+		options.host.kubeletPodsPath,
+	} {
+		assert.NilError(t, os.MkdirAll(path, 0755))
+	}
+
+	ownership.remove(vClusterKey(options))
+	t.Cleanup(func() { ownership.remove(vClusterKey(options)) })
+
+	return &testHost{
+		t:           t,
+		ctx:         context.WithValue(context.Background(), optionsKey, options),
+		options:     options,
+		virtualPath: virtualPath,
+	}
+}
+
+// addPod creates the virtual pod with a single container and the files
+// kubelet creates for its physical pod
+func (h *testHost) addPod(name string) (corev1.Pod, corev1.Pod) {
+	vPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "app", ContainerID: "containerd://123"}},
+		},
+	}
+	pPod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name + "-x-default-x-vcluster",
+		Namespace: "vcluster-ns",
+		UID:       types.UID("p-uid-" + name),
+		Annotations: map[string]string{
+			translate.NameAnnotation:      name,
+			translate.NamespaceAnnotation: "default",
+			translate.UIDAnnotation:       string(vPod.UID),
+		},
+	}}
+
+	layout := h.options.layout()
+	podLogDir := fmt.Sprintf("%s_%s_%s", pPod.Namespace, pPod.Name, pPod.UID)
+	assert.NilError(h.t, os.MkdirAll(filepath.Join(layout.podLogsPath, podLogDir, "app"), 0755))
+	assert.NilError(h.t, os.WriteFile(filepath.Join(layout.podLogsPath, podLogDir, "app", "0.log"), nil, 0644))
+	assert.NilError(h.t, os.MkdirAll(filepath.Join(layout.kubeletPodsPath, string(pPod.UID), "volumes"), 0755))
+	assert.NilError(h.t, os.Symlink(
+		filepath.Join(PodLogsMountPath, podLogDir, "app", "0.log"),
+		filepath.Join(layout.containerLogsPath, fmt.Sprintf(ContainerSymlinkSourceTemplate, pPod.Name, pPod.Namespace, "app", "123")),
+	))
+
+	return vPod, pPod
+}
+
+// removePodLogs removes the log files of the physical pod, like kubelet does
+// once the pod is gone
+func (h *testHost) removePodLogs(pPod corev1.Pod) {
+	layout := h.options.layout()
+	assert.NilError(h.t, os.RemoveAll(filepath.Join(layout.podLogsPath, fmt.Sprintf("%s_%s_%s", pPod.Namespace, pPod.Name, pPod.UID))))
+	assert.NilError(h.t, os.Remove(filepath.Join(layout.containerLogsPath, fmt.Sprintf(ContainerSymlinkSourceTemplate, pPod.Name, pPod.Namespace, "app", "123"))))
+}
+
+// removeKubeletDir removes the kubelet directory of the physical pod
+func (h *testHost) removeKubeletDir(pPod corev1.Pod) {
+	assert.NilError(h.t, os.RemoveAll(filepath.Join(h.options.layout().kubeletPodsPath, string(pPod.UID))))
+}
+
+// sync runs a resync with the given virtual and physical pods
+func (h *testHost) sync(vPods, pPods []corev1.Pod, cleanup bool, retries *mapRetries) {
+	guard := newDeletionGuard(&VirtualClusterOptions{MaxDeletionFraction: 1})
+	syncHostPaths(h.ctx, vPods, newPhysicalPodIndex(pPods), cleanup, guard, retries, retries.podEvents)
+}
+
+// virtualPaths returns the entries of the virtual paths relative to the
+// virtual path, with the targets of the symlinks and an empty string for
+// directories
+func (h *testHost) virtualPaths() map[string]string {
+	paths := map[string]string{}
+	for _, root := range []string{h.options.VirtualPodLogsPath, h.options.VirtualContainerLogsPath, h.options.VirtualKubeletPodPath} {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || path == root {
+				return err
+			}
+
+			relPath, err := filepath.Rel(h.virtualPath, path)
+			if err != nil {
+				return err
+			}
+
+			if entry.Type()&fs.ModeSymlink == 0 {
+				paths[relPath] = ""
+				return nil
+			}
+
+			paths[relPath], err = os.Readlink(path)
+			return err
+		})
+		assert.NilError(h.t, err)
+	}
+
+	return paths
+}
+
+// faultyFS fails to create symlinks whose path contains failPath
+type faultyFS struct {
+	hostFS
+	failPath string
+}
+
+func (f *faultyFS) Symlink(oldname, newname string) error {
+	if strings.Contains(newname, f.failPath) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: os.ErrPermission}
+	}
+
+	return f.hostFS.Symlink(oldname, newname)
+}
+
+func Test_layout(t *testing.T) {
+	options := &VirtualClusterOptions{}
+	assert.Equal(t, options.layout(), defaultHostLayout)
+	assert.Equal(t, options.layout().podLogsPath, PodLogsMountPath)
+
+	host := newTestHost(t)
+	assert.Assert(t, host.options.layout() != defaultHostLayout)
+	assert.Equal(t, host.options.fs(), hostFS(osFS{}))
+}
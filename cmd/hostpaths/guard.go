@@ -0,0 +1,151 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+const (
+	cleanupBlockedCachesNotSynced = "caches_not_synced"
+	cleanupBlockedMassDeletion    = "mass_deletion"
+)
+
+// stalePath is a virtual path of a removed pod or container instance
+type stalePath struct {
+	kind string
+	path string
+}
+
+// cleanupPlan collects the stale paths of a resync, so the deletion guard
+// can check them before anything is removed
+type cleanupPlan struct {
+	retention *retentionState
+	owned     *ownedPaths
+
+	// total is the number of entries in the virtual paths, foreign the
+	// number of stale entries the mapper did not create
+	total   int
+	foreign int
+	stale   []stalePath
+}
+
+func newCleanupPlan(retention *retentionState, owned *ownedPaths) *cleanupPlan {
+	return &cleanupPlan{retention: retention, owned: owned}
+}
+
+func (p *cleanupPlan) add(kind, path string) {
+	p.stale = append(p.stale, stalePath{kind: kind, path: path})
+}
+
+// addForeign reports a stale path that is kept, as the mapper did not create it
+func (p *cleanupPlan) addForeign(path string) {
+	p.foreign++
+	p.owned.reportForeign(path)
+}
+
+// key identifies the set of stale paths across resyncs
+func (p *cleanupPlan) key() string {
+	paths := make([]string, 0, len(p.stale))
+	for _, stale := range p.stale {
+		paths = append(paths, stale.path)
+	}
+
+	sort.Strings(paths)
+	return strings.Join(paths, "\n")
+}
+
+// remove deletes the stale paths
+func (p *cleanupPlan) remove(ctx context.Context) {
+	for _, stale := range p.stale {
+		err := removePath(ctx, stale.kind, stale.path)
+		if err != nil {
+			klog.Errorf("error deleting symlink %s: %v", stale.path, err)
+		}
+	}
+}
+
+// deletionGuard keeps the mapper from wiping the virtual paths when the
+// virtual cluster returns an empty or truncated pod list, e.g. right after
+// it was restarted or while it is asleep. A cleanup that removes more than
+// the max fraction of the virtual paths only happens once the same paths
+// were found stale in consecutive resyncs. Resyncs only confirm a cleanup
+// once half a resync interval passed since the last confirmation, so a burst
+// of resyncs can't confirm it before the virtual cluster recovered.
+type deletionGuard struct {
+	maxFraction   float64
+	confirmations int
+	minSpacing    time.Duration
+
+	// pending identifies the last blocked cleanup, confirmed counts the
+	// consecutive resyncs that planned it, the last one at confirmedAt
+	pending     string
+	confirmed   int
+	confirmedAt time.Time
+}
+
+func newDeletionGuard(options *VirtualClusterOptions) *deletionGuard {
+	return &deletionGuard{
+		maxFraction:   options.MaxDeletionFraction,
+		confirmations: options.DeletionConfirmations,
+		minSpacing:    options.ResyncInterval / 2,
+	}
+}
+
+// allow returns whether the stale paths of the plan can be removed
+func (g *deletionGuard) allow(ctx context.Context, plan *cleanupPlan, now time.Time) bool {
+	vCluster := vClusterLabel(ctx)
+	if len(plan.stale) == 0 || g.maxFraction >= 1 || float64(len(plan.stale)) <= g.maxFraction*float64(plan.total) {
+		g.pending = ""
+		g.confirmed = 0
+		pendingDeletions.WithLabelValues(vCluster).Set(0)
+		return true
+	}
+
+	key := plan.key()
+	if key != g.pending {
+		g.pending = key
+		g.confirmed = 1
+		g.confirmedAt = now
+	} else if now.Sub(g.confirmedAt) >= g.minSpacing {
+		g.confirmed++
+		g.confirmedAt = now
+	}
+
+	if g.confirmed >= g.confirmations {
+		klog.Infof("deleting %d of %d virtual paths, confirmed by %d consecutive resyncs", len(plan.stale), plan.total, g.confirmed)
+		g.pending = ""
+		g.confirmed = 0
+		pendingDeletions.WithLabelValues(vCluster).Set(0)
+		return true
+	}
+
+	klog.Infof("holding back the deletion of %d of %d virtual paths, which exceeds the max deletion fraction %.2f, until it is confirmed by %d consecutive resyncs (%d so far)", len(plan.stale), plan.total, g.maxFraction, g.confirmations, g.confirmed)
+	pendingDeletions.WithLabelValues(vCluster).Set(float64(len(plan.stale)))
+	cleanupBlocked.WithLabelValues(vCluster, cleanupBlockedMassDeletion).Inc()
+	return false
+}
+
+// podCachesSynced returns whether the pod informers of the managers have
+// synced, before that the pod lists might be incomplete
+func podCachesSynced(ctx context.Context, managers ...manager.Manager) (bool, error) {
+	for _, m := range managers {
+		informer, err := m.GetCache().GetInformer(ctx, &corev1.Pod{}, cache.BlockUntilSynced(false))
+		if err != nil {
+			return false, fmt.Errorf("get pod informer: %w", err)
+		}
+
+		if !informer.HasSynced() {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,89 @@
+package hostpaths
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func Test_deletionGuard(t *testing.T) {
+	options := &VirtualClusterOptions{MaxDeletionFraction: 0.5, DeletionConfirmations: 3}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+
+	newPlan := func(total int, paths ...string) *cleanupPlan {
+		plan := newCleanupPlan(newRetentionState(), nil)
+		plan.total = total
+		for _, path := range paths {
+			plan.add(SymlinkKindPodLog, path)
+		}
+
+		return plan
+	}
+
+	testCases := []struct {
+		name     string
+		plans    []*cleanupPlan
+		expected []bool
+	}{
+		{
+			name:     "Nothing to delete",
+			plans:    []*cleanupPlan{newPlan(0), newPlan(10)},
+			expected: []bool{true, true},
+		},
+		{
+			name:     "Deletion within the max fraction",
+			plans:    []*cleanupPlan{newPlan(4, "a", "b")},
+			expected: []bool{true},
+		},
+		{
+			name:     "Mass deletion confirmed by consecutive resyncs",
+			plans:    []*cleanupPlan{newPlan(4, "a", "b", "c"), newPlan(4, "c", "b", "a"), newPlan(4, "a", "b", "c"), newPlan(4, "a", "b", "c")},
+			expected: []bool{false, false, true, false},
+		},
+		{
+			name:     "Mass deletion of changing paths",
+			plans:    []*cleanupPlan{newPlan(4, "a", "b", "c"), newPlan(4, "a", "b", "d"), newPlan(4, "a", "b", "d"), newPlan(4, "a", "b", "d")},
+			expected: []bool{false, false, false, true},
+		},
+		{
+			name:     "Mass deletion resolved in between",
+			plans:    []*cleanupPlan{newPlan(4, "a", "b", "c"), newPlan(4, "a"), newPlan(4, "a", "b", "c"), newPlan(4, "a", "b", "c")},
+			expected: []bool{false, true, false, false},
+		},
+	}
+
+	for _, testCase := range testCases {
+		guard := newDeletionGuard(options)
+		for i, plan := range testCase.plans {
+			assert.Equal(t, guard.allow(ctx, plan, time.Time{}), testCase.expected[i], "Unexpected result for resync %d in test case %s", i, testCase.name)
+		}
+	}
+}
+
+func Test_deletionGuardSpacing(t *testing.T) {
+	options := &VirtualClusterOptions{MaxDeletionFraction: 0.5, DeletionConfirmations: 3, ResyncInterval: time.Minute}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+
+	plan := newCleanupPlan(newRetentionState(), nil)
+	plan.total = 4
+	for _, path := range []string{"a", "b", "c"} {
+		plan.add(SymlinkKindPodLog, path)
+	}
+
+	// a burst of resyncs, e.g. for many removed pods, counts only once
+	guard := newDeletionGuard(options)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		assert.Assert(t, !guard.allow(ctx, plan, start.Add(time.Duration(i)*time.Millisecond)), "Unexpected deletion in resync %d of the burst", i)
+	}
+
+	assert.Assert(t, !guard.allow(ctx, plan, start.Add(time.Minute)))
+	assert.Assert(t, !guard.allow(ctx, plan, start.Add(time.Minute+time.Second)))
+	assert.Assert(t, guard.allow(ctx, plan, start.Add(2*time.Minute)))
+}
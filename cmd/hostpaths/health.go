@@ -0,0 +1,226 @@
+package hostpaths
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// health tracks the mapping state of every vCluster served by this process
+var health = &healthRegistry{
+	vClusters: map[string]*mappingHealth{},
+}
+
+type healthRegistry struct {
+	m         sync.Mutex
+	vClusters map[string]*mappingHealth
+}
+
+// mappingHealth is the mapping state of a single vCluster
+type mappingHealth struct {
+	m sync.Mutex
+
+	// vCluster is the key of the vCluster in the metrics
+	vCluster string
+
+	// connected is the time the virtual cluster api server was reachable
+	connected time.Time
+
+	// reconcileStarted is the start of the currently running reconcile
+	reconcileStarted time.Time
+
+	// lastReconcile is the last time a reconcile finished, lastSuccess the
+	// last time one finished without an error
+	lastReconcile time.Time
+	lastSuccess   time.Time
+
+	// discovered is set for the vClusters of the central mapper, which only
+	// fail the probes together
+	discovered bool
+}
+
+func (r *healthRegistry) forVCluster(vCluster string) *mappingHealth {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	h, ok := r.vClusters[vCluster]
+	if !ok {
+		h = &mappingHealth{vCluster: vCluster}
+		r.vClusters[vCluster] = h
+	}
+
+	return h
+}
+
+// reset marks a vCluster as not connected, e.g. when its mapper restarts
+func (r *healthRegistry) reset(vCluster string) {
+	h := r.forVCluster(vCluster)
+
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.connected = time.Time{}
+	h.reconcileStarted = time.Time{}
+	h.lastReconcile = time.Time{}
+	h.lastSuccess = time.Time{}
+	recordConnected(h.vCluster, false)
+	recordLastSuccess(h.vCluster, h.lastSuccess)
+}
+
+// discover resets a vCluster the central mapper found and checks it together
+// with the other vClusters it found
+func (r *healthRegistry) discover(vCluster string) {
+	r.reset(vCluster)
+
+	h := r.forVCluster(vCluster)
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.discovered = true
+}
+
+func (r *healthRegistry) remove(vCluster string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.vClusters, vCluster)
+}
+
+// check runs checkFn for every vCluster and joins the errors. The vClusters
+// the central mapper discovered only fail the check together, once at least
+// failureFraction of them failed, so a single broken vCluster doesn't fail
+// the mapper of all others.
+func (r *healthRegistry) check(failureFraction float64, checkFn func(h *mappingHealth, now time.Time) error) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	vClusters := make([]string, 0, len(r.vClusters))
+	for vCluster := range r.vClusters {
+		vClusters = append(vClusters, vCluster)
+	}
+	sort.Strings(vClusters)
+
+	now := time.Now()
+	errs := []error{}
+	discovered := 0
+	discoveredErrs := []error{}
+	for _, vCluster := range vClusters {
+		h := r.vClusters[vCluster]
+		err := checkFn(h, now)
+		if err != nil {
+			err = fmt.Errorf("vCluster %s: %w", vCluster, err)
+		}
+
+		h.m.Lock()
+		isDiscovered := h.discovered
+		h.m.Unlock()
+		if isDiscovered {
+			discovered++
+			if err != nil {
+				discoveredErrs = append(discoveredErrs, err)
+			}
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(discoveredErrs) > 0 && float64(len(discoveredErrs)) >= failureFraction*float64(discovered) {
+		errs = append(errs, fmt.Errorf("%d of %d vClusters failed: %w", len(discoveredErrs), discovered, errors.Join(discoveredErrs...)))
+	}
+
+	return errors.Join(errs...)
+}
+
+// readyzCheck fails if a vCluster is not connected yet or has not finished a
+// reconcile successfully within threshold
+func (r *healthRegistry) readyzCheck(threshold time.Duration, failureFraction float64) healthz.Checker {
+	return func(_ *http.Request) error {
+		return r.check(failureFraction, func(h *mappingHealth, now time.Time) error {
+			h.m.Lock()
+			defer h.m.Unlock()
+
+			if h.connected.IsZero() {
+				return errors.New("virtual cluster is not connected yet")
+			} else if h.lastSuccess.IsZero() {
+				return errors.New("no successful reconcile yet")
+			} else if now.Sub(h.lastSuccess) > threshold {
+				return fmt.Errorf("last successful reconcile was %s ago", now.Sub(h.lastSuccess).Round(time.Second))
+			}
+
+			return nil
+		})
+	}
+}
+
+// healthzCheck fails if a reconcile of a connected vCluster is running or
+// has not finished for longer than threshold. Waiting for the virtual cluster
+// does not count as stuck, as restarting the mapper would not help.
+func (r *healthRegistry) healthzCheck(threshold time.Duration, failureFraction float64) healthz.Checker {
+	return func(_ *http.Request) error {
+		return r.check(failureFraction, func(h *mappingHealth, now time.Time) error {
+			h.m.Lock()
+			defer h.m.Unlock()
+
+			lastReconcile := h.lastReconcile
+			if lastReconcile.IsZero() {
+				lastReconcile = h.connected
+			}
+
+			if h.connected.IsZero() {
+				return nil
+			} else if !h.reconcileStarted.IsZero() && now.Sub(h.reconcileStarted) > threshold {
+				return fmt.Errorf("reconcile is running for %s", now.Sub(h.reconcileStarted).Round(time.Second))
+			} else if now.Sub(lastReconcile) > threshold {
+				return fmt.Errorf("no reconcile finished for %s", now.Sub(lastReconcile).Round(time.Second))
+			}
+
+			return nil
+		})
+	}
+}
+
+// disconnect marks the vCluster as not connected while its mapper connects
+// again, e.g. after its credentials changed, so the reconciles before are
+// not counted against the liveness. The last successful reconcile is kept
+// for the readiness once it is connected again.
+func (h *mappingHealth) disconnect() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.connected = time.Time{}
+	h.reconcileStarted = time.Time{}
+	h.lastReconcile = time.Time{}
+	recordConnected(h.vCluster, false)
+}
+
+func (h *mappingHealth) setConnected() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.connected = time.Now()
+	recordConnected(h.vCluster, true)
+}
+
+func (h *mappingHealth) startReconcile() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.reconcileStarted = time.Now()
+}
+
+func (h *mappingHealth) finishReconcile(err error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.reconcileStarted = time.Time{}
+	h.lastReconcile = time.Now()
+	if err == nil {
+		h.lastSuccess = h.lastReconcile
+		recordLastSuccess(h.vCluster, h.lastSuccess)
+	}
+}
@@ -0,0 +1,159 @@
+package hostpaths
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+)
+
+func Test_healthChecks(t *testing.T) {
+	now := time.Now()
+	threshold := 5 * time.Minute
+
+	testCases := []struct {
+		name          string
+		health        *mappingHealth
+		expectReady   bool
+		expectHealthy bool
+	}{
+		{
+			name:          "Waiting for virtual cluster",
+			health:        &mappingHealth{},
+			expectReady:   false,
+			expectHealthy: true,
+		},
+		{
+			name:          "Connected without reconcile",
+			health:        &mappingHealth{connected: now},
+			expectReady:   false,
+			expectHealthy: true,
+		},
+		{
+			name:          "Recent successful reconcile",
+			health:        &mappingHealth{connected: now.Add(-time.Hour), lastReconcile: now, lastSuccess: now},
+			expectReady:   true,
+			expectHealthy: true,
+		},
+		{
+			name:          "Only failing reconciles",
+			health:        &mappingHealth{connected: now.Add(-time.Hour), lastReconcile: now, lastSuccess: now.Add(-time.Hour)},
+			expectReady:   false,
+			expectHealthy: true,
+		},
+		{
+			name:          "Reconcile stuck",
+			health:        &mappingHealth{connected: now.Add(-time.Hour), reconcileStarted: now.Add(-time.Hour), lastReconcile: now.Add(-time.Hour), lastSuccess: now.Add(-time.Hour)},
+			expectReady:   false,
+			expectHealthy: false,
+		},
+		{
+			name:          "Never reconciled after connecting",
+			health:        &mappingHealth{connected: now.Add(-time.Hour)},
+			expectReady:   false,
+			expectHealthy: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		registry := &healthRegistry{vClusters: map[string]*mappingHealth{"vcluster/vcluster": testCase.health}}
+
+		err := registry.readyzCheck(threshold, 1)(nil)
+		assert.Equal(t, err == nil, testCase.expectReady, "Unexpected readiness in test case %s: %v", testCase.name, err)
+
+		err = registry.healthzCheck(threshold, 1)(nil)
+		assert.Equal(t, err == nil, testCase.expectHealthy, "Unexpected liveness in test case %s: %v", testCase.name, err)
+	}
+}
+
+func Test_healthChecksDiscovered(t *testing.T) {
+	threshold := 5 * time.Minute
+	registry := &healthRegistry{vClusters: map[string]*mappingHealth{}}
+	t.Cleanup(func() {
+		for _, vCluster := range []string{"vcluster-ns/a", "vcluster-ns/b", "vcluster-ns/c"} {
+			deleteVClusterMetrics(vCluster)
+		}
+	})
+
+	// no discovered vCluster is connected yet
+	for _, vCluster := range []string{"vcluster-ns/a", "vcluster-ns/b", "vcluster-ns/c"} {
+		registry.discover(vCluster)
+	}
+	assert.ErrorContains(t, registry.readyzCheck(threshold, 1)(nil), "3 of 3 vClusters failed")
+
+	// a single working vCluster keeps the mapper ready unless the failure
+	// fraction is lower
+	working := registry.forVCluster("vcluster-ns/a")
+	working.setConnected()
+	working.startReconcile()
+	working.finishReconcile(nil)
+	assert.NilError(t, registry.readyzCheck(threshold, 1)(nil))
+	assert.ErrorContains(t, registry.readyzCheck(threshold, 0.5)(nil), "2 of 3 vClusters failed")
+
+	// stuck reconciles fail the liveness the same way
+	for _, vCluster := range []string{"vcluster-ns/b", "vcluster-ns/c"} {
+		stuck := registry.forVCluster(vCluster)
+		stuck.setConnected()
+		stuck.startReconcile()
+		stuck.reconcileStarted = time.Now().Add(-time.Hour)
+	}
+	assert.NilError(t, registry.healthzCheck(threshold, 1)(nil))
+	assert.ErrorContains(t, registry.healthzCheck(threshold, 0.5)(nil), "reconcile is running")
+
+	working.startReconcile()
+	working.reconcileStarted = time.Now().Add(-time.Hour)
+	assert.ErrorContains(t, registry.healthzCheck(threshold, 1)(nil), "3 of 3 vClusters failed")
+
+	// discovered vClusters are still checked together when their mapper
+	// restarts
+	registry.reset("vcluster-ns/a")
+	registry.reset("vcluster-ns/b")
+	registry.reset("vcluster-ns/c")
+	assert.NilError(t, registry.healthzCheck(threshold, 1)(nil))
+	assert.ErrorContains(t, registry.readyzCheck(threshold, 1)(nil), "3 of 3 vClusters failed")
+}
+
+func Test_healthReconnect(t *testing.T) {
+	now := time.Now()
+	threshold := 5 * time.Minute
+	h := &mappingHealth{connected: now.Add(-time.Hour), lastReconcile: now.Add(-10 * time.Minute), lastSuccess: now.Add(-time.Minute)}
+	registry := &healthRegistry{vClusters: map[string]*mappingHealth{"vcluster/vcluster": h}}
+	assert.ErrorContains(t, registry.healthzCheck(threshold, 1)(nil), "no reconcile finished")
+
+	// waiting for the virtual cluster after the credentials changed
+	h.disconnect()
+	assert.NilError(t, registry.healthzCheck(threshold, 1)(nil))
+	assert.ErrorContains(t, registry.readyzCheck(threshold, 1)(nil), "not connected")
+
+	h.setConnected()
+	assert.NilError(t, registry.healthzCheck(threshold, 1)(nil))
+	assert.NilError(t, registry.readyzCheck(threshold, 1)(nil))
+}
+
+func Test_healthMetrics(t *testing.T) {
+	vCluster := "vcluster-ns/metrics"
+	registry := &healthRegistry{vClusters: map[string]*mappingHealth{}}
+	t.Cleanup(func() { deleteVClusterMetrics(vCluster) })
+	h := registry.forVCluster(vCluster)
+
+	h.setConnected()
+	assert.Equal(t, testutil.ToFloat64(connected.WithLabelValues(vCluster)), 1.0)
+	// deleting a series reports whether it existed
+	assert.Assert(t, !lastSuccess.DeleteLabelValues(vCluster))
+
+	h.startReconcile()
+	h.finishReconcile(nil)
+	success := float64(h.lastSuccess.UnixNano()) / float64(time.Second)
+	assert.Equal(t, testutil.ToFloat64(lastSuccess.WithLabelValues(vCluster)), success)
+
+	// a reconnect keeps the last success, a restart of the mapper forgets it
+	h.disconnect()
+	assert.Equal(t, testutil.ToFloat64(connected.WithLabelValues(vCluster)), 0.0)
+	assert.Equal(t, testutil.ToFloat64(lastSuccess.WithLabelValues(vCluster)), success)
+	registry.reset(vCluster)
+	assert.Assert(t, !lastSuccess.DeleteLabelValues(vCluster))
+
+	deleteVClusterMetrics(vCluster)
+	assert.Assert(t, !connected.DeleteLabelValues(vCluster))
+}
@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -15,15 +16,18 @@ import (
 	"github.com/loft-sh/vcluster/config"
 	"github.com/loft-sh/vcluster/config/legacyconfig"
 	"github.com/loft-sh/vcluster/pkg/util/blockingcacheclient"
+	"github.com/loft-sh/vcluster/pkg/util/namespaces"
 	"github.com/loft-sh/vcluster/pkg/util/pluginhookclient"
 	"github.com/loft-sh/vcluster/pkg/util/translate"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -48,8 +52,12 @@ func init() {
 type key int
 
 const (
-	LogsMountPath    = "/var/log"
-	PodLogsMountPath = "/var/log/pods"
+	ContainerLogsMountPath = "/var/log/containers"
+	PodLogsMountPath       = "/var/log/pods"
+
+	// VirtualPathMountPath is where the virtual paths of all vClusters are
+	// on the node and in the mapper
+	VirtualPathMountPath = "/tmp/vcluster"
 
 	NodeIndexName                    = "spec.nodeName"
 	HostpathMapperSelfNodeNameEnvVar = "VCLUSTER_HOSTPATH_MAPPER_CURRENT_NODE_NAME"
@@ -66,9 +74,6 @@ const (
 	configFilename           = "config.yaml"
 )
 
-// map of physical pod names to the corresponding virtual pod
-type PhysicalPodMap map[string]*PodDetail
-
 type PodDetail struct {
 	Target      string
 	SymLinkName *string
@@ -77,10 +82,87 @@ type PodDetail struct {
 
 type VirtualClusterOptions struct {
 	legacyconfig.LegacyVirtualClusterOptions
+
+	// VirtualRootPath contains the virtual paths of the vCluster and the
+	// state of the mapper. Unlike the paths below it is not mounted into the
+	// pods of the vCluster.
+	VirtualRootPath          string
 	VirtualLogsPath          string
 	VirtualPodLogsPath       string
 	VirtualContainerLogsPath string
 	VirtualKubeletPodPath    string
+
+	ResyncInterval time.Duration
+
+	// CredentialReloadInterval is how often the credentials of the virtual
+	// cluster are checked for changes, e.g. after a certificate rotation
+	CredentialReloadInterval time.Duration
+
+	// RetentionPeriod is how long the paths of removed pods are kept, so
+	// log agents can finish reading them
+	RetentionPeriod time.Duration
+
+	// RetainKubeletPaths keeps the kubelet paths of removed pods beyond the
+	// retention period while their physical kubelet directory exists
+	RetainKubeletPaths bool
+
+	// MaxDeletionFraction is the largest fraction of the virtual paths a
+	// resync removes, unless the same removal was planned by
+	// DeletionConfirmations consecutive resyncs
+	MaxDeletionFraction   float64
+	DeletionConfirmations int
+
+	MetricsBindAddress string
+
+	HealthProbeBindAddress string
+
+	// ReadinessThreshold is the maximum age of the last successful reconcile
+	// of a ready mapper, LivenessThreshold the maximum time a reconcile may
+	// be stuck before the mapper is considered unhealthy
+	ReadinessThreshold time.Duration
+	LivenessThreshold  time.Duration
+
+	// ProbeFailureFraction is the fraction of the vClusters of the central
+	// mapper that need to fail a check before the probe fails
+	ProbeFailureFraction float64
+
+	// RestartConcurrency, RestartInterval and RestartTimeout control how the
+	// init container restarts the pods that mount the host paths
+	RestartConcurrency int
+	RestartInterval    time.Duration
+	RestartTimeout     time.Duration
+	RestartUnownedPods bool
+
+	// RestartOptIn only restarts pods with the restart annotation set to
+	// true, RestartManagedOnly only pods synced by this vCluster
+	RestartOptIn       bool
+	RestartManagedOnly bool
+
+	// DryRun only reports the symlinks the mapper would create, the paths
+	// it would delete and the pods it would restart
+	DryRun       bool
+	DryRunOutput string
+
+	// Central maps the paths of all vClusters on the host cluster that use
+	// the central host path mapper
+	Central bool
+
+	translator translate.Translator
+
+	// HostLayout overrides the paths the node's directories are mounted to,
+	// HostLayoutFile is a yaml file with the same settings
+	HostLayout     HostLayoutConfig
+	HostLayoutFile string
+
+	// VirtualKubeConfig and VirtualKubeConfigSecret read the config of the
+	// virtual cluster from a kubeconfig file or secret instead of the
+	// certificates, VirtualServer overrides the server it is reached at
+	VirtualKubeConfig       string
+	VirtualKubeConfigSecret string
+	VirtualServer           string
+
+	// host is where the physical paths of the node are found
+	host *hostLayout
 }
 
 func NewHostpathMapperCommand() *cobra.Command {
@@ -92,22 +174,59 @@ func NewHostpathMapperCommand() *cobra.Command {
 		Short: "Map host to virtual pod logs",
 		Args:  cobra.NoArgs,
 		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			err := setupHostLayout(cobraCmd.Flags(), options)
+			if err != nil {
+				return err
+			}
+
 			return Start(cobraCmd.Context(), options, init)
 		},
 	}
 
-	cmd.Flags().StringVar(&options.ClientCaCert, "client-ca-cert", "/data/server/tls/client-certificate", "The path to the client ca certificate")
-	cmd.Flags().StringVar(&options.ServerCaCert, "server-ca-cert", "/data/server/tls/certificate-authority", "The path to the server ca certificate")
-	cmd.Flags().StringVar(&options.ServerCaKey, "server-ca-key", "/data/server/tls/client-key", "The path to the server ca key")
-
-	cmd.Flags().StringVar(&options.TargetNamespace, "target-namespace", "", "The namespace to run the virtual cluster in (defaults to current namespace)")
-
-	cmd.Flags().StringVar(&options.Name, "name", "vcluster", "The name of the virtual cluster")
+	addVirtualClusterFlags(cmd.Flags(), options)
+	addHostLayoutFlags(cmd.Flags(), options)
 	cmd.Flags().BoolVar(&init, "init", false, "If this is the init container")
+	cmd.Flags().BoolVar(&options.Central, "central", false, "If enabled, maps the paths of all virtual clusters on the host cluster that have the central hostpath mapper enabled")
+	cmd.Flags().StringVar(&options.MetricsBindAddress, "metrics-bind-address", "0", "The address the metrics endpoint binds to, 0 disables the endpoint")
+	cmd.Flags().DurationVar(&options.CredentialReloadInterval, "credential-reload-interval", 30*time.Second, "The interval in which the credentials of the virtual cluster are checked for changes, the mapper reconnects with the new ones, 0 disables the check")
+	cmd.Flags().DurationVar(&options.ResyncInterval, "resync-interval", time.Minute, "The interval in which all pods on the node are mapped again and stale paths are cleaned up")
+	cmd.Flags().DurationVar(&options.RetentionPeriod, "retention-period", 5*time.Minute, "How long the pod log, container log and kubelet paths of removed pods are kept before they are deleted, unless the physical files are gone earlier")
+	cmd.Flags().BoolVar(&options.RetainKubeletPaths, "retain-kubelet-paths", false, "If enabled, the kubelet paths of removed pods are kept beyond the retention period as long as their physical kubelet directory exists, e.g. for velero backups")
+	cmd.Flags().Float64Var(&options.MaxDeletionFraction, "max-deletion-fraction", 0.5, "The largest fraction of the virtual paths a resync deletes without confirmation, 1 disables the check")
+	cmd.Flags().IntVar(&options.DeletionConfirmations, "deletion-confirmations", 3, "The number of consecutive resyncs that need to find the same paths stale before more than the max deletion fraction is deleted")
+	cmd.Flags().StringVar(&options.HealthProbeBindAddress, "health-probe-bind-address", "0", "The address the /healthz and /readyz endpoints bind to, 0 disables the endpoints")
+	cmd.Flags().DurationVar(&options.ReadinessThreshold, "readiness-threshold", 5*time.Minute, "The mapper is not ready if its last successful reconcile is older than this, needs to be larger than the resync interval")
+	cmd.Flags().DurationVar(&options.LivenessThreshold, "liveness-threshold", 10*time.Minute, "The mapper is not healthy if no reconcile finished for this long, needs to be larger than the resync interval")
+	cmd.Flags().Float64Var(&options.ProbeFailureFraction, "probe-failure-fraction", 1, "In central mode, the fraction of the virtual clusters that need to fail the readiness or liveness check before the probe fails, 1 fails it only if all of them fail")
+
+	cmd.Flags().BoolVar(&options.DryRun, "dry-run", false, "If enabled, only reports the symlinks that would be created, the paths that would be deleted and the pods that would be restarted")
+	cmd.Flags().StringVar(&options.DryRunOutput, "dry-run-output", DryRunOutputLog, "How to report dry run actions, log or json (one object per line on stdout)")
+
+	cmd.Flags().IntVar(&options.RestartConcurrency, "restart-concurrency", 1, "The number of pods the init container restarts at the same time")
+	cmd.Flags().DurationVar(&options.RestartInterval, "restart-interval", time.Second, "The minimum time between starting two pod restarts in the init container")
+	cmd.Flags().DurationVar(&options.RestartTimeout, "restart-timeout", 5*time.Minute, "How long the init container retries an eviction blocked by a PodDisruptionBudget and waits for the replacement pods to become ready, the other pods of a controller whose replacement is not ready in time are not restarted, 0 does not wait for replacements")
+	cmd.Flags().BoolVar(&options.RestartUnownedPods, "restart-unowned-pods", false, "If enabled, the init container also restarts pods without a controller, which are not recreated")
+
+	cmd.Flags().BoolVar(&options.RestartOptIn, "restart-opt-in", false, "If enabled, the init container only restarts pods with the "+RestartAnnotation+"=true annotation or label")
+	cmd.Flags().BoolVar(&options.RestartManagedOnly, "restart-managed-only", true, "If enabled, the init container only restarts pods that carry the markers of pods synced by this virtual cluster")
 
 	return cmd
 }
 
+// addVirtualClusterFlags adds the flags that select the virtual cluster and
+// how to connect to it
+func addVirtualClusterFlags(flags *pflag.FlagSet, options *VirtualClusterOptions) {
+	flags.StringVar(&options.ClientCaCert, "client-ca-cert", "/data/server/tls/client-certificate", "The path to the client ca certificate")
+	flags.StringVar(&options.ServerCaCert, "server-ca-cert", "/data/server/tls/certificate-authority", "The path to the server ca certificate")
+	flags.StringVar(&options.ServerCaKey, "server-ca-key", "/data/server/tls/client-key", "The path to the server ca key")
+
+	flags.StringVar(&options.TargetNamespace, "target-namespace", "", "The namespace to run the virtual cluster in (defaults to current namespace)")
+
+	flags.StringVar(&options.Name, "name", "vcluster", "The name of the virtual cluster")
+
+	addVirtualConnectionFlags(flags, options)
+}
+
 func podNodeIndexer(obj client.Object) []string {
 	res := []string{}
 	pod := obj.(*corev1.Pod)
@@ -119,6 +238,29 @@ func podNodeIndexer(obj client.Object) []string {
 }
 
 func Start(ctx context.Context, options *VirtualClusterOptions, init bool) error {
+	err := validateOptions(options)
+	if err != nil {
+		return err
+	}
+
+	// the init container only restarts pods and needs none of the paths
+	if !init {
+		err = options.layout().checkMounted()
+		if err != nil {
+			return fmt.Errorf("check host paths: %w", err)
+		}
+	}
+
+	inClusterConfig := ctrl.GetConfigOrDie()
+
+	inClusterConfig.QPS = 40
+	inClusterConfig.Burst = 80
+	inClusterConfig.Timeout = 0
+
+	if options.Central {
+		return startCentral(ctx, inClusterConfig, options, init)
+	}
+
 	// get current namespace
 	currentNamespace, err := clienthelper.CurrentNamespace()
 	if err != nil {
@@ -130,32 +272,141 @@ func Start(ctx context.Context, options *VirtualClusterOptions, init bool) error
 		options.TargetNamespace = currentNamespace
 	}
 
-	virtualPath := fmt.Sprintf(podtranslate.VirtualPathTemplate, options.TargetNamespace, options.Name)
-	options.VirtualKubeletPodPath = filepath.Join(virtualPath, "kubelet", "pods")
-	options.VirtualLogsPath = filepath.Join(virtualPath, "log")
-	options.VirtualPodLogsPath = filepath.Join(options.VirtualLogsPath, "pods")
-	options.VirtualContainerLogsPath = filepath.Join(options.VirtualLogsPath, "containers")
+	setVirtualPaths(options)
 
-	inClusterConfig := ctrl.GetConfigOrDie()
+	kubeClient, err := kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		return fmt.Errorf("create kube client: %w", err)
+	}
 
-	inClusterConfig.QPS = 40
-	inClusterConfig.Burst = 80
-	inClusterConfig.Timeout = 0
+	err = findVclusterModeAndSetTranslator(ctx, kubeClient, options)
+	if err != nil {
+		return fmt.Errorf("find vcluster mode: %w", err)
+	}
+
+	// pods of a namespace syncing vCluster can be in any host namespace
+	localCacheOptions := cache.Options{}
+	if options.translator.SingleNamespaceTarget() {
+		localCacheOptions.DefaultNamespaces = map[string]cache.Config{options.TargetNamespace: {}}
+	}
+
+	localManager, err := newLocalManager(inClusterConfig, options, localCacheOptions)
+	if err != nil {
+		return err
+	}
+
+	// the local manager serves the probes, so start it before waiting for
+	// the virtual cluster, the mapper is reported not ready in the meantime
+	vClusterHealth := health.forVCluster(vClusterKey(options))
+	startManager(ctx, localManager)
+
+	loadConfig := func(ctx context.Context) (*rest.Config, error) {
+		return getVirtualClusterConfig(ctx, kubeClient, options)
+	}
+
+	ctx = context.WithValue(ctx, optionsKey, options)
+
+	if init {
+		virtualClusterConfig, err := loadConfig(ctx)
+		if err != nil {
+			return err
+		}
 
-	translate.VClusterName = options.Name
-
-	var virtualClusterConfig *rest.Config
-	err = wait.PollUntilContextTimeout(ctx, time.Second, time.Hour, true, func(context.Context) (bool, error) {
-		virtualClusterConfig = &rest.Config{
-			Host: options.Name,
-			TLSClientConfig: rest.TLSClientConfig{
-				ServerName: options.Name,
-				CertFile:   options.ClientCaCert,
-				KeyFile:    options.ServerCaKey,
-				CAFile:     options.ServerCaCert,
-			},
+		err = waitForVirtualCluster(ctx, virtualClusterConfig)
+		if err != nil {
+			return err
 		}
 
+		vClusterHealth.setConnected()
+
+		klog.Info("is init container mode")
+		return restartTargetPods(ctx, options, kubeClient, localManager.GetClient())
+	}
+
+	klog.Info("mapping hostpaths")
+	err = ensureVirtualPaths(options)
+	if err != nil {
+		return err
+	}
+
+	return runWithCredentialReload(ctx, options, loadConfig, func(ctx context.Context, virtualClusterConfig *rest.Config) error {
+		return mapHostPaths(ctx, options, localManager, virtualClusterConfig)
+	})
+}
+
+func validateOptions(options *VirtualClusterOptions) error {
+	err := validateConnectionOptions(options)
+	if err != nil {
+		return err
+	}
+
+	if options.RestartConcurrency < 1 {
+		return fmt.Errorf("restart concurrency needs to be at least 1")
+	}
+
+	if options.DryRunOutput != DryRunOutputLog && options.DryRunOutput != DryRunOutputJSON {
+		return fmt.Errorf("unsupported dry run output %q, use %s or %s", options.DryRunOutput, DryRunOutputLog, DryRunOutputJSON)
+	}
+
+	if options.ResyncInterval <= 0 {
+		return fmt.Errorf("resync interval needs to be positive")
+	}
+
+	if options.CredentialReloadInterval < 0 {
+		return fmt.Errorf("credential reload interval must not be negative")
+	}
+
+	if options.RetentionPeriod < 0 {
+		return fmt.Errorf("retention period must not be negative")
+	}
+
+	if options.MaxDeletionFraction < 0 || options.MaxDeletionFraction > 1 {
+		return fmt.Errorf("max deletion fraction needs to be between 0 and 1")
+	} else if options.DeletionConfirmations < 1 {
+		return fmt.Errorf("deletion confirmations need to be at least 1")
+	}
+
+	if options.HealthProbeBindAddress != "0" {
+		if options.ReadinessThreshold <= options.ResyncInterval {
+			return fmt.Errorf("readiness threshold %s needs to be larger than the resync interval %s", options.ReadinessThreshold, options.ResyncInterval)
+		} else if options.LivenessThreshold <= options.ResyncInterval {
+			return fmt.Errorf("liveness threshold %s needs to be larger than the resync interval %s", options.LivenessThreshold, options.ResyncInterval)
+		} else if options.ProbeFailureFraction <= 0 || options.ProbeFailureFraction > 1 {
+			return fmt.Errorf("probe failure fraction needs to be larger than 0 and at most 1")
+		}
+	}
+
+	return nil
+}
+
+// setVirtualPaths sets the paths of the virtual tree of the vCluster the
+// options point to
+func setVirtualPaths(options *VirtualClusterOptions) {
+	options.VirtualRootPath = options.layout().vClusterPath(options.TargetNamespace, options.Name)
+	options.VirtualKubeletPodPath = filepath.Join(options.VirtualRootPath, "kubelet", "pods")
+	options.VirtualLogsPath = filepath.Join(options.VirtualRootPath, "log")
+	options.VirtualPodLogsPath = filepath.Join(options.VirtualLogsPath, "pods")
+	options.VirtualContainerLogsPath = filepath.Join(options.VirtualLogsPath, "containers")
+}
+
+// ensureVirtualPaths creates the directories of the virtual tree that are
+// not already mounted into the mapper
+func ensureVirtualPaths(options *VirtualClusterOptions) error {
+	for _, path := range []string{options.VirtualPodLogsPath, options.VirtualKubeletPodPath, options.VirtualContainerLogsPath} {
+		err := makeDirectory(options, path)
+		if err != nil {
+			klog.Errorf("error creating virtual path %s: %v", path, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForVirtualCluster blocks until the virtual cluster api server is
+// reachable and the default service account was created
+func waitForVirtualCluster(ctx context.Context, virtualClusterConfig *rest.Config) error {
+	return wait.PollUntilContextTimeout(ctx, time.Second, time.Hour, true, func(context.Context) (bool, error) {
 		kubeClient, err := kubernetes.NewForConfig(virtualClusterConfig)
 		if err != nil {
 			return false, fmt.Errorf("create kube client: %w", err)
@@ -174,63 +425,43 @@ func Start(ctx context.Context, options *VirtualClusterOptions, init bool) error
 
 		return true, nil
 	})
-	if err != nil {
-		return err
-	}
+}
 
-	kubeClient, err := kubernetes.NewForConfig(inClusterConfig)
+// newLocalManager creates the manager for the host cluster, which also serves
+// the metrics and health probes of the process
+func newLocalManager(inClusterConfig *rest.Config, options *VirtualClusterOptions, cacheOptions cache.Options) (manager.Manager, error) {
+	localManager, err := ctrl.NewManager(inClusterConfig, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: options.MetricsBindAddress},
+		HealthProbeBindAddress: options.HealthProbeBindAddress,
+		LeaderElection:         false,
+		NewClient:              pluginhookclient.NewPhysicalPluginClientFactory(blockingcacheclient.NewCacheClient),
+		Cache:                  cacheOptions,
+	})
 	if err != nil {
-		return fmt.Errorf("create kube client: %w", err)
+		return nil, err
 	}
 
-	err = findVclusterModeAndSetDefaultTranslation(ctx, kubeClient, options)
+	err = localManager.AddHealthzCheck("mapper", health.healthzCheck(options.LivenessThreshold, options.ProbeFailureFraction))
 	if err != nil {
-		return fmt.Errorf("find vcluster mode: %w", err)
+		return nil, fmt.Errorf("add healthz check: %w", err)
 	}
 
-	localManager, err := ctrl.NewManager(inClusterConfig, ctrl.Options{
-		Scheme:         scheme,
-		Metrics:        metricsserver.Options{BindAddress: "0"},
-		LeaderElection: false,
-		NewClient:      pluginhookclient.NewPhysicalPluginClientFactory(blockingcacheclient.NewCacheClient),
-		Cache: cache.Options{
-			DefaultNamespaces: map[string]cache.Config{options.TargetNamespace: {}},
-		},
-	})
+	err = localManager.AddReadyzCheck("mapper", health.readyzCheck(options.ReadinessThreshold, options.ProbeFailureFraction))
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("add readyz check: %w", err)
 	}
 
-	virtualClusterManager, err := ctrl.NewManager(virtualClusterConfig, ctrl.Options{
+	return localManager, nil
+}
+
+func newVirtualClusterManager(virtualClusterConfig *rest.Config) (manager.Manager, error) {
+	return ctrl.NewManager(virtualClusterConfig, ctrl.Options{
 		Scheme:         scheme,
 		Metrics:        metricsserver.Options{BindAddress: "0"},
 		LeaderElection: false,
 		NewClient:      pluginhookclient.NewVirtualPluginClientFactory(blockingcacheclient.NewCacheClient),
 	})
-	if err != nil {
-		return err
-	}
-
-	ctx = context.WithValue(ctx, optionsKey, options)
-
-	startManagers(ctx, localManager, virtualClusterManager)
-
-	if init {
-		klog.Info("is init container mode")
-		defer ctx.Done()
-		return restartTargetPods(ctx, options, localManager, virtualClusterManager)
-	}
-
-	klog.Info("mapping hostpaths")
-	err = os.Mkdir(options.VirtualContainerLogsPath, os.ModeDir)
-	if err != nil {
-		if !os.IsExist(err) {
-			klog.Errorf("error creating container dir in log path: %v", err)
-			return err
-		}
-	}
-
-	return mapHostPaths(ctx, localManager, virtualClusterManager)
 }
 
 func getVclusterConfigFromSecret(ctx context.Context, kubeClient kubernetes.Interface, vclusterName, vclusterNamespace string) (*config.Config, error) {
@@ -239,6 +470,10 @@ func getVclusterConfigFromSecret(ctx context.Context, kubeClient kubernetes.Inte
 		return nil, err
 	}
 
+	return parseVclusterConfigSecret(configSecret)
+}
+
+func parseVclusterConfigSecret(configSecret *corev1.Secret) (*config.Config, error) {
 	rawBytes, ok := configSecret.Data[configFilename]
 	if !ok {
 		return nil, fmt.Errorf("key '%s' not found in secret", configFilename)
@@ -246,7 +481,7 @@ func getVclusterConfigFromSecret(ctx context.Context, kubeClient kubernetes.Inte
 
 	// create a new strict decoder
 	rawConfig := &config.Config{}
-	err = yaml.UnmarshalStrict(rawBytes, rawConfig)
+	err := yaml.UnmarshalStrict(rawBytes, rawConfig)
 	if err != nil {
 		klog.Errorf("unmarshal %s: %#+v", configFilename, errors.Unwrap(err))
 		return nil, err
@@ -255,200 +490,284 @@ func getVclusterConfigFromSecret(ctx context.Context, kubeClient kubernetes.Inte
 	return rawConfig, nil
 }
 
-func findVclusterModeAndSetDefaultTranslation(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) error {
+func findVclusterModeAndSetTranslator(ctx context.Context, kubeClient kubernetes.Interface, options *VirtualClusterOptions) error {
 	vClusterConfig, err := getVclusterConfigFromSecret(ctx, kubeClient, options.Name, options.TargetNamespace)
 	if err != nil && !kerrors.IsNotFound(err) {
 		return err
-	} else if vClusterConfig != nil && vClusterConfig.Sync.ToHost.Namespaces.Enabled {
-		return fmt.Errorf("unsupported vCluster config. Hostpathmapper is not compatible with toHost namespace syncing (sync.toHost.namespaces)")
 	}
 
-	translate.Default = translate.NewSingleNamespaceTranslator(options.TargetNamespace)
-	return nil
+	options.translator, err = newTranslator(options, vClusterConfig)
+	return err
 }
 
-func restartTargetPods(ctx context.Context, options *VirtualClusterOptions, localManager, virtualClusterManager manager.Manager) error {
-	pPodList := &corev1.PodList{}
+// newTranslator returns the name translation matching the vCluster config,
+// a nil config means the vCluster uses the defaults
+func newTranslator(options *VirtualClusterOptions, vClusterConfig *config.Config) (translate.Translator, error) {
+	if vClusterConfig != nil && vClusterConfig.Sync.ToHost.Namespaces.Enabled {
+		err := namespaces.ValidateNamespaceSyncConfig(vClusterConfig, options.Name, options.TargetNamespace)
+		if err != nil {
+			return nil, fmt.Errorf("validate namespace sync config: %w", err)
+		}
 
-	err := localManager.GetClient().List(ctx, pPodList, &client.ListOptions{
-		FieldSelector: fields.SelectorFromSet(fields.Set{
-			NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
-		}),
-	})
+		klog.Infof("vCluster %s/%s syncs namespaces to host, using multi namespace translation", options.TargetNamespace, options.Name)
+		return newMultiNamespaceTranslator(options.Name, options.TargetNamespace, vClusterConfig.Sync.ToHost.Namespaces.Mappings.ByName), nil
+	}
+
+	return newSingleNamespaceTranslator(options.Name, options.TargetNamespace), nil
+}
+
+// mapHostPaths connects to the virtual cluster and maps the paths of its pods
+// until ctx is done
+func mapHostPaths(ctx context.Context, options *VirtualClusterOptions, pManager manager.Manager, virtualClusterConfig *rest.Config) error {
+	vClusterHealth := health.forVCluster(vClusterKey(options))
+	vClusterHealth.disconnect()
 
+	err := waitForVirtualCluster(ctx, virtualClusterConfig)
 	if err != nil {
-		klog.Errorf("unable to list pods: %v", err)
 		return err
 	}
 
-	podRestartList := []corev1.Pod{}
+	vClusterHealth.setConnected()
 
-podLoop:
-	for _, pPod := range pPodList.Items {
-		// skip current pod itself
-		if pPod.Name == os.Getenv(PodNameEnv) {
-			klog.Infof("skipping self pod %s", pPod.Name)
-			continue
-		}
+	vManager, err := newVirtualClusterManager(virtualClusterConfig)
+	if err != nil {
+		return err
+	}
 
-		klog.Infof("processing pod %s", pPod.Name)
+	err = vManager.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, NodeIndexName, podNodeIndexer)
+	if err != nil {
+		return err
+	}
 
-		for _, volume := range pPod.Spec.Volumes {
-			if volume.VolumeSource.HostPath != nil {
-				if volume.VolumeSource.HostPath.Path == podtranslate.PodLoggingHostPath ||
-					volume.VolumeSource.HostPath.Path == podtranslate.LogHostPath ||
-					volume.VolumeSource.HostPath.Path == podtranslate.KubeletPodPath {
-					klog.Infof("adding pod %s to restart list", pPod.Name)
-					podRestartList = append(podRestartList, pPod)
-					continue podLoop
-				}
-			}
-		}
+	err = registerMapperController(options, pManager, vManager)
+	if err != nil {
+		return fmt.Errorf("register mapper controller: %w", err)
 	}
 
-	klog.Infof("restart list %d", len(podRestartList))
+	return vManager.Start(ctx)
+}
 
-	// translate to physical pod name and delete
-	// this would require us to know wether multinamespace mode or single namespace mode?
-	for _, pPod := range podRestartList {
-		klog.Infof("deleting physical pod %s", pPod.Name)
+// resyncHostPaths maps all virtual pods on the current node and cleans up
+// paths of pods that no longer exist, as far as the guard allows it. Pods that
+// cannot be mapped are handed to retries and do not fail the resync.
+func resyncHostPaths(ctx context.Context, pManager, vManager manager.Manager, guard *deletionGuard, retries *mapRetries, podEvents *podEvents) error {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
 
-		err = localManager.GetClient().Delete(ctx, &pPod)
-		if err != nil {
-			klog.Errorf("error deleting target pod %s: %v", pPod.Name, err)
-		}
+	// cleanup old pod symlinks, but only with complete pod lists
+	synced, err := podCachesSynced(ctx, pManager, vManager)
+	if err != nil {
+		return err
+	}
+
+	vPodList := &corev1.PodList{}
+	err = vManager.GetClient().List(ctx, vPodList, &client.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{
+			NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
+		}),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to list virtual pods: %w", err)
 	}
 
+	hostNamespaces := map[string]struct{}{}
+	for _, vPod := range vPodList.Items {
+		hostNamespaces[options.translator.HostNamespace(nil, vPod.Namespace)] = struct{}{}
+	}
+
+	podIndex, err := getPhysicalPodIndex(ctx, pManager.GetClient(), hostNamespaces)
+	if err != nil {
+		return fmt.Errorf("unable to get physical pod mapping: %w", err)
+	}
+
+	syncHostPaths(ctx, vPodList.Items, podIndex, synced, guard, retries, podEvents)
 	return nil
 }
 
-func mapHostPaths(ctx context.Context, pManager, vManager manager.Manager) error {
+// syncHostPaths maps the given virtual pods on the current node and, if
+// cleanup is set, removes the paths of all other pods
+func syncHostPaths(ctx context.Context, vPods []corev1.Pod, podIndex *physicalPodIndex, cleanup bool, guard *deletionGuard, retries *mapRetries, podEvents *podEvents) {
 	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
 
-	mapFunc := func() error {
-		podMappings, err := getPhysicalPodMap(ctx, options, pManager)
-		if err != nil {
-			klog.Errorf("unable to get physical pod mapping: %v", err)
-			return nil
+	// virtual pods on the node with the container instances whose logs are kept
+	existingVPodsWithNamespace := make(map[string]map[string]bool)
+	existingPodsPath := make(map[string]bool)
+	existingKubeletPodsPath := make(map[string]bool)
+	mappedPods := 0
+
+	for _, vPod := range vPods {
+		containerInstances := map[string]bool{}
+		for _, containerStatus := range allContainerStatuses(&vPod) {
+			for _, containerID := range containerInstanceIDs(containerStatus) {
+				containerInstances[containerStatus.Name+"-"+containerID] = true
+			}
 		}
+		existingVPodsWithNamespace[fmt.Sprintf("%s_%s", vPod.Name, vPod.Namespace)] = containerInstances
 
-		vPodList := &corev1.PodList{}
-		err = vManager.GetClient().List(ctx, vPodList, &client.ListOptions{
-			FieldSelector: fields.SelectorFromSet(fields.Set{
-				NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
-			}),
-		})
-		if err != nil {
-			klog.Errorf("unable to list pods: %v", err)
-			return nil
+		pPod := podIndex.lookup(options.translator, &vPod)
+		if pPod == nil {
+			podEvents.physicalPodNotFound(&vPod)
+			continue
 		}
 
-		existingVPodsWithNamespace := make(map[string]bool)
-		existingPodsPath := make(map[string]bool)
-		existingKubeletPodsPath := make(map[string]bool)
+		podDetail, ok := getPodDetail(options, *pPod)
+		if !ok {
+			podEvents.logDirectoryNotFound(&vPod, pPod)
+			continue
+		}
 
-		for _, vPod := range vPodList.Items {
-			existingVPodsWithNamespace[fmt.Sprintf("%s_%s", vPod.Name, vPod.Namespace)] = true
-			pName := translate.Default.HostName(nil, vPod.Name, vPod.Namespace).Name
+		mapping, err := mapVirtualPod(ctx, vPod, podDetail)
+		existingPodsPath[mapping.podLogPath] = true
+		existingKubeletPodsPath[mapping.kubeletPodPath] = true
+		if err != nil {
+			retries.failed(ctx, &vPod, err)
+			retries.retry(ctx, &vPod)
+			continue
+		}
 
-			if podDetail, ok := podMappings[pName]; ok {
-				// create pod log symlink
-				source := filepath.Join(options.VirtualPodLogsPath, fmt.Sprintf("%s_%s_%s", vPod.Namespace, vPod.Name, string(vPod.UID)))
-				target := filepath.Join(podtranslate.PhysicalPodLogVolumeMountPath, podDetail.Target)
+		podEvents.mapped(&vPod, mapping)
+		mappedPods++
+	}
 
-				existingPodsPath[source] = true
+	existingUIDs := make(map[types.UID]bool, len(vPods))
+	for _, vPod := range vPods {
+		existingUIDs[vPod.UID] = true
+	}
+	podEvents.prune(existingUIDs)
+
+	vCluster := vClusterLabel(ctx)
+	virtualPods.WithLabelValues(vCluster, "mapped").Set(float64(mappedPods))
+	virtualPods.WithLabelValues(vCluster, "unmapped").Set(float64(len(vPods) - mappedPods))
+
+	// cleanup old pod symlinks, but only with complete pod lists
+	if !cleanup {
+		klog.Infof("skipping cleanup, the pod caches have not synced yet")
+		cleanupBlocked.WithLabelValues(vCluster, cleanupBlockedCachesNotSynced).Inc()
+		if err := saveOwnedPaths(options); err != nil {
+			klog.Errorf("error saving owned paths: %v", err)
+		}
+		return
+	}
 
-				_, err := createPodLogSymlinkToPhysical(source, target)
-				if err != nil {
-					return fmt.Errorf("unable to create symlink for %s: %w", podDetail.Target, err)
-				}
+	plan := cleanupVirtualPaths(ctx, existingPodsPath, existingVPodsWithNamespace, existingKubeletPodsPath, guard)
+	foreignPaths.WithLabelValues(vCluster).Set(float64(plan.foreign))
 
-				// create kubelet pod symlink
-				kubeletPodSymlinkSource := filepath.Join(options.VirtualKubeletPodPath, string(vPod.GetUID()))
-				kubeletPodSymlinkTarget := filepath.Join(podtranslate.PhysicalKubeletVolumeMountPath, string(podDetail.PhysicalPod.GetUID()))
-				existingKubeletPodsPath[kubeletPodSymlinkSource] = true
-				err = createKubeletVirtualToPhysicalPodLinks(kubeletPodSymlinkSource, kubeletPodSymlinkTarget)
-				if err != nil {
-					return err
-				}
+	danglingSymlinks.WithLabelValues(vCluster).Set(float64(countDanglingSymlinks(options)))
 
-				// podDetail.SymLinkName = symlinkName
+	klog.Infof("successfully reconciled mapper")
+}
 
-				// create container to vPod symlinks
-				containerSymlinkTargetDir := filepath.Join(PodLogsMountPath,
-					fmt.Sprintf("%s_%s_%s", vPod.Namespace, vPod.Name, string(vPod.UID)))
-				err = createContainerToPodSymlink(ctx, vPod, podDetail, containerSymlinkTargetDir)
-				if err != nil {
-					return err
-				}
-			}
-		}
+// cleanupVirtualPaths removes the paths of pods that are not in the existing
+// paths, as far as the ownership, the retention period and the guard allow
+// it, and persists the state of the cleanup
+func cleanupVirtualPaths(ctx context.Context, existingPodsPath map[string]bool, existingVPodsWithNamespace map[string]map[string]bool, existingKubeletPodsPath map[string]bool, guard *deletionGuard) *cleanupPlan {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
 
-		// cleanup old pod symlinks
-		err = cleanupOldPodPath(ctx, options.VirtualPodLogsPath, existingPodsPath)
-		if err != nil {
-			klog.Errorf("error cleaning up old pod log paths: %v", err)
-		}
+	plan := newCleanupPlan(loadRetentionState(options), ownership.forVCluster(options))
+	err := cleanupOldPodPath(ctx, plan, SymlinkKindPodLog, options.VirtualPodLogsPath, existingPodsPath)
+	if err != nil {
+		klog.Errorf("error cleaning up old pod log paths: %v", err)
+	}
 
-		err = cleanupOldContainerPaths(ctx, existingVPodsWithNamespace)
-		if err != nil {
-			klog.Errorf("error cleaning up old container log paths: %v", err)
-		}
+	err = cleanupOldContainerPaths(ctx, plan, existingVPodsWithNamespace)
+	if err != nil {
+		klog.Errorf("error cleaning up old container log paths: %v", err)
+	}
 
-		err = cleanupOldPodPath(ctx, options.VirtualKubeletPodPath, existingKubeletPodsPath)
-		if err != nil {
-			klog.Errorf("error cleaning up old kubelet pod paths: %v", err)
-		}
+	err = cleanupOldPodPath(ctx, plan, SymlinkKindKubelet, options.VirtualKubeletPodPath, existingKubeletPodsPath)
+	if err != nil {
+		klog.Errorf("error cleaning up old kubelet pod paths: %v", err)
+	}
 
-		klog.Infof("successfully reconciled mapper")
-		return nil
+	if guard.allow(ctx, plan, time.Now()) {
+		plan.remove(ctx)
 	}
 
-	for {
-		err := mapFunc()
-		if err != nil {
-			return err
-		}
+	err = plan.retention.save(options)
+	if err != nil {
+		klog.Errorf("error saving retention state: %v", err)
+	}
 
-		time.Sleep(5 * time.Second)
+	err = saveOwnedPaths(options)
+	if err != nil {
+		klog.Errorf("error saving owned paths: %v", err)
 	}
+
+	return plan
 }
 
-func getPhysicalPodMap(ctx context.Context, options *VirtualClusterOptions, pManager manager.Manager) (PhysicalPodMap, error) {
-	podListOptions := &client.ListOptions{
-		FieldSelector: fields.SelectorFromSet(fields.Set{
-			NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
-		}),
-		Namespace: options.TargetNamespace,
+// podMapping is the outcome of mapping a single virtual pod
+type podMapping struct {
+	// podLogPath and kubeletPodPath are the virtual paths the pod uses
+	podLogPath     string
+	kubeletPodPath string
+
+	physicalPod *corev1.Pod
+
+	// missingContainerLogs are the containers without an entry in
+	// /var/log/containers, whose logs could therefore not be linked
+	missingContainerLogs []string
+}
+
+// mapVirtualPod creates the pod log, kubelet and container symlinks of a
+// single virtual pod. The returned mapping is set even if an error occurred.
+func mapVirtualPod(ctx context.Context, vPod corev1.Pod, podDetail *PodDetail) (*podMapping, error) {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+
+	// create pod log symlink
+	source := filepath.Join(options.VirtualPodLogsPath, fmt.Sprintf("%s_%s_%s", vPod.Namespace, vPod.Name, string(vPod.UID)))
+	target := filepath.Join(podtranslate.PhysicalPodLogVolumeMountPath, podDetail.Target)
+	mapping := &podMapping{
+		podLogPath:     source,
+		kubeletPodPath: filepath.Join(options.VirtualKubeletPodPath, string(vPod.GetUID())),
+		physicalPod:    &podDetail.PhysicalPod,
 	}
 
-	podList := &corev1.PodList{}
-	err := pManager.GetClient().List(ctx, podList, podListOptions)
+	created, err := createPodLogSymlinkToPhysical(ctx, source, target)
 	if err != nil {
-		return nil, fmt.Errorf("unable to list pods: %w", err)
+		return mapping, fmt.Errorf("unable to create symlink for %s: %w", podDetail.Target, err)
+	} else if created && vPod.Status.StartTime != nil {
+		logLinkLatency.WithLabelValues(vClusterLabel(ctx)).Observe(time.Since(vPod.Status.StartTime.Time).Seconds())
 	}
 
-	podMappings := make(PhysicalPodMap, len(podList.Items))
-	for _, pPod := range podList.Items {
-		lookupName := fmt.Sprintf("%s_%s_%s", pPod.Namespace, pPod.Name, pPod.UID)
+	// create kubelet pod symlink
+	err = createKubeletVirtualToPhysicalPodLinks(ctx, mapping.kubeletPodPath, podDetail.PhysicalPod.GetUID())
+	if err != nil {
+		return mapping, err
+	}
 
-		ok, err := checkIfPathExists(lookupName)
-		if err != nil {
-			klog.Errorf("error checking existence for path %s: %v", lookupName, err)
-		}
+	if options.layout().noContainerLogs {
+		return mapping, nil
+	}
 
-		if ok {
-			// check entry in podMapping
-			if _, ok := podMappings[pPod.Name]; !ok {
-				podMappings[pPod.Name] = &PodDetail{
-					Target:      lookupName,
-					PhysicalPod: pPod,
-				}
-			}
-		}
+	// create container to vPod symlinks
+	containerSymlinkTargetDir := filepath.Join(PodLogsMountPath,
+		fmt.Sprintf("%s_%s_%s", vPod.Namespace, vPod.Name, string(vPod.UID)))
+	mapping.missingContainerLogs, err = createContainerToPodSymlink(ctx, vPod, podDetail, containerSymlinkTargetDir)
+	if err != nil {
+		return mapping, err
+	}
+
+	return mapping, nil
+}
+
+// getPodDetail returns the mapping target of the physical pod, if kubelet
+// already created its log directory
+func getPodDetail(options *VirtualClusterOptions, pPod corev1.Pod) (*PodDetail, bool) {
+	lookupName := fmt.Sprintf("%s_%s_%s", pPod.Namespace, pPod.Name, pPod.UID)
+
+	ok, err := checkIfPathExists(options, lookupName)
+	if err != nil {
+		klog.Errorf("error checking existence for path %s: %v", lookupName, err)
+	}
+
+	if !ok {
+		return nil, false
 	}
 
-	return podMappings, nil
+	return &PodDetail{
+		Target:      lookupName,
+		PhysicalPod: pPod,
+	}, true
 }
 
 func filter(ctx context.Context, podList []corev1.Pod, vclusterNamespaces map[string]struct{}) []corev1.Pod {
@@ -462,27 +781,36 @@ func filter(ctx context.Context, podList []corev1.Pod, vclusterNamespaces map[st
 	return pods
 }
 
-func cleanupOldContainerPaths(ctx context.Context, existingVPodsWithNS map[string]bool) error {
+// cleanupOldContainerPaths plans the removal of the container log symlinks of
+// pods that no longer exist and of container instances that are neither the
+// current nor the last terminated instance of a container of their pod, once
+// their retention period passed
+func cleanupOldContainerPaths(ctx context.Context, plan *cleanupPlan, existingVPodsWithNS map[string]map[string]bool) error {
 	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+	now := time.Now()
 
-	vPodsContainersOnDisk, err := os.ReadDir(options.VirtualContainerLogsPath)
+	vPodsContainersOnDisk, err := options.fs().ReadDir(options.VirtualContainerLogsPath)
 	if err != nil {
 		return err
 	}
 
+	plan.total += len(vPodsContainersOnDisk)
 	for _, vPodContainerOnDisk := range vPodsContainersOnDisk {
-		nameParts := strings.Split(vPodContainerOnDisk.Name(), "_")
-		vPodOnDiskName, vPodOnDiskNS := nameParts[0], nameParts[1]
+		vPodOnDiskName, vPodOnDiskNS, containerOnDiskName, containerOnDiskID, ok := parseContainerSymlinkName(vPodContainerOnDisk.Name())
+		if !ok {
+			klog.V(1).Infof("skipping cleanup of %s, which is no container log symlink", vPodContainerOnDisk.Name())
+			continue
+		}
 
-		if _, ok := existingVPodsWithNS[fmt.Sprintf("%s_%s", vPodOnDiskName, vPodOnDiskNS)]; !ok {
-			// this pod no longer exists, hence this container
-			// belonging to it should no longer exist either
+		containerInstances, ok := existingVPodsWithNS[fmt.Sprintf("%s_%s", vPodOnDiskName, vPodOnDiskNS)]
+		if !ok || !containerInstances[containerOnDiskName+"-"+containerOnDiskID] {
+			// this pod or container instance no longer exists, hence
+			// the symlink of the container should no longer exist either
 			fullPathToCleanup := filepath.Join(options.VirtualContainerLogsPath, vPodContainerOnDisk.Name())
-
-			klog.Infof("cleaning up %s", fullPathToCleanup)
-			err := os.RemoveAll(fullPathToCleanup)
-			if err != nil {
-				klog.Errorf("error deleting symlink %s: %v", fullPathToCleanup, err)
+			if !plan.owned.owns(fullPathToCleanup) {
+				plan.addForeign(fullPathToCleanup)
+			} else if plan.retention.shouldRemove(options, SymlinkKindContainerLog, fullPathToCleanup, now) {
+				plan.add(SymlinkKindContainerLog, fullPathToCleanup)
 			}
 		}
 	}
@@ -490,8 +818,12 @@ func cleanupOldContainerPaths(ctx context.Context, existingVPodsWithNS map[strin
 	return nil
 }
 
-func createKubeletVirtualToPhysicalPodLinks(vPodDirName, pPodDirName string) error {
-	err := os.MkdirAll(vPodDirName, os.ModeDir)
+// createKubeletVirtualToPhysicalPodLinks links the entries of the kubelet
+// directory of the physical pod into the kubelet directory of the virtual pod
+func createKubeletVirtualToPhysicalPodLinks(ctx context.Context, vPodDirName string, pPodUID types.UID) error {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+
+	err := makeDirectory(options, vPodDirName)
 	if err != nil {
 		return fmt.Errorf("error creating vPod kubelet directory for %s: %w", vPodDirName, err)
 	}
@@ -499,123 +831,156 @@ func createKubeletVirtualToPhysicalPodLinks(vPodDirName, pPodDirName string) err
 	// scan all contents in the physical pod dir
 	// and create equivalent symlinks from virtual
 	// path to physical
-	contents, err := os.ReadDir(pPodDirName)
+	pPodDirName := filepath.Join(options.layout().kubeletPodsPath, string(pPodUID))
+	contents, err := options.fs().ReadDir(pPodDirName)
 	if err != nil {
 		return fmt.Errorf("error reading physical kubelet pod dir %s: %w", pPodDirName, err)
 	}
 
 	for _, content := range contents {
 		fullKubeletVirtualPodPath := filepath.Join(vPodDirName, content.Name())
-		fullKubeletPhysicalPodPath := filepath.Join(pPodDirName, content.Name())
+		fullKubeletPhysicalPodPath := filepath.Join(podtranslate.PhysicalKubeletVolumeMountPath, string(pPodUID), content.Name())
 
-		err := os.Symlink(
-			fullKubeletPhysicalPodPath,
-			fullKubeletVirtualPodPath)
+		_, err := ensureSymlink(ctx, SymlinkKindKubelet, fullKubeletPhysicalPodPath, fullKubeletVirtualPodPath)
 		if err != nil {
-			if !os.IsExist(err) {
-				return fmt.Errorf("error creating symlink for %s -> %s: %w", fullKubeletVirtualPodPath, fullKubeletPhysicalPodPath, err)
-			}
-		} else {
-			klog.Infof("created kubelet pod symlink %s -> %s", fullKubeletVirtualPodPath, fullKubeletPhysicalPodPath)
+			return fmt.Errorf("error creating symlink for %s -> %s: %w", fullKubeletVirtualPodPath, fullKubeletPhysicalPodPath, err)
 		}
 	}
 
 	return nil
 }
 
-func cleanupOldPodPath(ctx context.Context, cleanupDirPath string, existingPodPathsFromAPIServer map[string]bool) error {
-	vPodDirsOnDisk, err := os.ReadDir(cleanupDirPath)
+// cleanupOldPodPath plans the removal of the entries of cleanupDirPath that
+// belong to no existing virtual pod once their retention period passed
+func cleanupOldPodPath(ctx context.Context, plan *cleanupPlan, kind, cleanupDirPath string, existingPodPathsFromAPIServer map[string]bool) error {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+	vPodDirsOnDisk, err := options.fs().ReadDir(cleanupDirPath)
 	if err != nil {
 		return err
 	}
 
-	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+	now := time.Now()
 
+	plan.total += len(vPodDirsOnDisk)
 	for _, vPodDirOnDisk := range vPodDirsOnDisk {
 		fullVPodDirDiskPath := filepath.Join(cleanupDirPath, vPodDirOnDisk.Name())
 		if _, ok := existingPodPathsFromAPIServer[fullVPodDirDiskPath]; !ok {
-			if cleanupDirPath == options.VirtualKubeletPodPath {
-				// check if the symlinks resolve
-				// this extra check for kubelet is because velero backups
-				// depend on it and we don't want to delete the virtual paths
-				// which the physical paths are still not cleaned up by the
-				// kubelet
-				symlinks, err := os.ReadDir(fullVPodDirDiskPath)
-				if err != nil {
-					klog.Errorf("error iterating over vpod dir %s: %v", fullVPodDirDiskPath, err)
-				}
+			// this symlink source exists on the disk but the vPod
+			// lo longer exists as per the API server. Log agents might
+			// still read it and velero backups depend on the kubelet
+			// paths, so it is only deleted once it is retained long
+			// enough or the physical paths were cleaned up by the kubelet
+			if !plan.owned.owns(fullVPodDirDiskPath) {
+				plan.addForeign(fullVPodDirDiskPath)
+			} else if plan.retention.shouldRemove(options, kind, fullVPodDirDiskPath, now) {
+				plan.add(kind, fullVPodDirDiskPath)
+			}
+		}
+	}
+
+	return nil
+}
+
+// createContainerToPodSymlink links the logs of the current and last terminated
+// instance of every container and returns the containers whose current
+// instance has no physical container log symlink
+func createContainerToPodSymlink(ctx context.Context, vPod corev1.Pod, pPodDetail *PodDetail, targetDir string) ([]string, error) {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
 
-				for _, sl := range symlinks {
-					target := filepath.Join(fullVPodDirDiskPath, sl.Name())
-					_, readLinkErr := os.Readlink(target)
-					if readLinkErr != nil {
-						// symlink no longer resolves, hence delete
-						klog.Infof("cleaning up %s", target)
-						err := os.RemoveAll(target)
-						if err != nil {
-							klog.Errorf("error deleting symlink %s: %v", target, err)
-						}
+	missing := []string{}
+	for _, containerStatus := range allContainerStatuses(&vPod) {
+		containerName := containerStatus.Name
+		for i, containerID := range containerInstanceIDs(containerStatus) {
+			source := fmt.Sprintf(ContainerSymlinkSourceTemplate,
+				vPod.Name,
+				vPod.Namespace,
+				containerName,
+				containerID)
+
+			pPod := pPodDetail.PhysicalPod
+			physicalContainerFileName := fmt.Sprintf(ContainerSymlinkSourceTemplate,
+				pPod.Name,
+				pPod.Namespace,
+				containerName,
+				containerID)
+
+			physicalLogFileName, err := getPhysicalLogFilename(ctx, physicalContainerFileName)
+			if err != nil {
+				if i > 0 && os.IsNotExist(err) {
+					// kubelet already removed the logs of the terminated instance
+					klog.V(1).Infof("no physical container symlink for terminated container %s: %v", physicalContainerFileName, err)
+				} else {
+					klog.Errorf("error reading destination filename from physical container symlink: %v", err)
+					if i == 0 && os.IsNotExist(err) {
+						missing = append(missing, containerName)
 					}
 				}
 				continue
 			}
 
-			// this symlink source exists on the disk but the vPod
-			// lo longer exists as per the API server, hence delete
-			// the symlink
-			klog.Infof("cleaning up %s", fullVPodDirDiskPath)
-			err := os.RemoveAll(fullVPodDirDiskPath)
+			target := filepath.Join(targetDir, containerName, physicalLogFileName)
+			source = filepath.Join(options.VirtualContainerLogsPath, source)
+
+			_, err = ensureSymlink(ctx, SymlinkKindContainerLog, target, source)
 			if err != nil {
-				klog.Errorf("error deleting symlink %s: %v", fullVPodDirDiskPath, err)
+				return missing, fmt.Errorf("error creating container:%s to pod:%s symlink: %w", source, target, err)
 			}
 		}
 	}
 
-	return nil
+	return missing, nil
 }
 
-func createContainerToPodSymlink(ctx context.Context, vPod corev1.Pod, pPodDetail *PodDetail, targetDir string) error {
-	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
-
-	for _, containerStatus := range vPod.Status.ContainerStatuses {
-		_, containerID, _ := strings.Cut(containerStatus.ContainerID, "://")
-		containerName := containerStatus.Name
+// containerInstanceIDs returns the ids of the current and the last terminated
+// instance of the container, which are the instances kubelet keeps the logs of
+func containerInstanceIDs(containerStatus corev1.ContainerStatus) []string {
+	containerIDs := []string{}
+	if _, containerID, _ := strings.Cut(containerStatus.ContainerID, "://"); containerID != "" {
+		containerIDs = append(containerIDs, containerID)
+	}
 
-		source := fmt.Sprintf(ContainerSymlinkSourceTemplate,
-			vPod.Name,
-			vPod.Namespace,
-			containerName,
-			containerID)
+	if terminated := containerStatus.LastTerminationState.Terminated; terminated != nil {
+		_, containerID, _ := strings.Cut(terminated.ContainerID, "://")
+		if containerID != "" && !slices.Contains(containerIDs, containerID) {
+			containerIDs = append(containerIDs, containerID)
+		}
+	}
 
-		pPod := pPodDetail.PhysicalPod
-		physicalContainerFileName := fmt.Sprintf(ContainerSymlinkSourceTemplate,
-			pPod.Name,
-			pPod.Namespace,
-			containerName,
-			containerID)
+	return containerIDs
+}
 
-		physicalLogFileName, err := getPhysicalLogFilename(ctx, physicalContainerFileName)
-		if err != nil {
-			klog.Errorf("error reading destination filename from physical container symlink: %v", err)
-			continue
-		}
+// allContainerStatuses returns the statuses of the init, sidecar, regular and
+// ephemeral containers of the pod, which all log to /var/log/containers
+func allContainerStatuses(pod *corev1.Pod) []corev1.ContainerStatus {
+	statuses := make([]corev1.ContainerStatus, 0, len(pod.Status.InitContainerStatuses)+len(pod.Status.ContainerStatuses)+len(pod.Status.EphemeralContainerStatuses))
+	statuses = append(statuses, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	statuses = append(statuses, pod.Status.EphemeralContainerStatuses...)
+	return statuses
+}
 
-		target := filepath.Join(targetDir, containerName, physicalLogFileName)
-		source = filepath.Join(options.VirtualContainerLogsPath, source)
+// parseContainerSymlinkName splits a name of the ContainerSymlinkSourceTemplate
+// format. Pod, namespace and container names cannot contain underscores.
+func parseContainerSymlinkName(name string) (podName, namespace, containerName, containerID string, ok bool) {
+	nameParts := strings.Split(strings.TrimSuffix(name, ".log"), "_")
+	if len(nameParts) != 3 || !strings.HasSuffix(name, ".log") {
+		return "", "", "", "", false
+	}
 
-		err = os.Symlink(target, source)
-		if err != nil {
-			if !os.IsExist(err) {
-				return fmt.Errorf("error creating container:%s to pod:%s symlink: %w", source, target, err)
-			}
+	containerName, containerID, ok = cutLast(nameParts[2], "-")
+	if !ok {
+		return "", "", "", "", false
+	}
 
-			continue
-		}
+	return nameParts[0], nameParts[1], containerName, containerID, true
+}
 
-		klog.Infof("created container:%s -> pod:%s symlink", source, target)
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
 	}
 
-	return nil
+	return s, "", false
 }
 
 // we need to get the info that which log file in the physical pod dir
@@ -623,8 +988,10 @@ func createContainerToPodSymlink(ctx context.Context, vPod corev1.Pod, pPodDetai
 // <physical_container> -> /var/log/pods/<pod>/<container>/xxx.log
 // <virtual_container> -> <virtual_pod_path>/<container>/xxx.log
 func getPhysicalLogFilename(ctx context.Context, physicalContainerFileName string) (string, error) {
-	pContainerFilePath := filepath.Join(LogsMountPath, "containers", physicalContainerFileName)
-	pDestination, err := os.Readlink(pContainerFilePath)
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+
+	pContainerFilePath := filepath.Join(options.layout().containerLogsPath, physicalContainerFileName)
+	pDestination, err := options.fs().Readlink(pContainerFilePath)
 	if err != nil {
 		return "", err
 	}
@@ -635,11 +1002,35 @@ func getPhysicalLogFilename(ctx context.Context, physicalContainerFileName strin
 	return fileName, nil
 }
 
-// check if folder exists
-func checkIfPathExists(path string) (bool, error) {
-	fullPath := filepath.Join(PodLogsMountPath, path)
+// mapperPath translates a symlink target, which is only valid within the
+// pods of the vCluster, to the path of the same file within the mapper
+func mapperPath(options *VirtualClusterOptions, target string) string {
+	switch {
+	case strings.HasPrefix(target, podtranslate.PhysicalPodLogVolumeMountPath+"/"):
+		return filepath.Join(options.layout().podLogsPath, strings.TrimPrefix(target, podtranslate.PhysicalPodLogVolumeMountPath))
+	case strings.HasPrefix(target, podtranslate.PhysicalKubeletVolumeMountPath+"/"):
+		return filepath.Join(options.layout().kubeletPodsPath, strings.TrimPrefix(target, podtranslate.PhysicalKubeletVolumeMountPath))
+	case strings.HasPrefix(target, PodLogsMountPath+"/"):
+		// the pods of the vCluster see the virtual pod logs under
+		// /var/log/pods, whose entries are symlinks to the physical ones
+		podDir, rest, _ := strings.Cut(strings.TrimPrefix(target, PodLogsMountPath+"/"), "/")
+		podLink := filepath.Join(options.VirtualPodLogsPath, podDir)
+		podTarget, err := options.fs().Readlink(podLink)
+		if err != nil {
+			return filepath.Join(podLink, rest)
+		}
+
+		return filepath.Join(mapperPath(options, podTarget), rest)
+	default:
+		return target
+	}
+}
+
+// checkIfPathExists checks if the physical pod log folder exists
+func checkIfPathExists(options *VirtualClusterOptions, path string) (bool, error) {
+	fullPath := filepath.Join(options.layout().podLogsPath, path)
 
-	if _, err := os.Stat(fullPath); err != nil {
+	if _, err := options.fs().Stat(fullPath); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return false, nil
 		}
@@ -650,42 +1041,22 @@ func checkIfPathExists(path string) (bool, error) {
 	return true, nil
 }
 
-func startManagers(ctx context.Context, pManager, vManager manager.Manager) {
-	err := pManager.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, NodeIndexName, podNodeIndexer)
-	if err != nil {
-		panic(err)
-	}
-
-	go func() {
-		err := pManager.Start(ctx)
-		if err != nil {
-			panic(err)
-		}
-	}()
-
-	err = vManager.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, NodeIndexName, podNodeIndexer)
+func startManager(ctx context.Context, m manager.Manager) {
+	err := m.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, NodeIndexName, podNodeIndexer)
 	if err != nil {
 		panic(err)
 	}
 
 	go func() {
-		err := vManager.Start(ctx)
+		err := m.Start(ctx)
 		if err != nil {
 			panic(err)
 		}
 	}()
 }
 
-func createPodLogSymlinkToPhysical(vPodDirName, pPodDirName string) (*string, error) {
-	err := os.Symlink(pPodDirName, vPodDirName)
-	if err != nil {
-		if os.IsExist(err) {
-			return &vPodDirName, nil
-		}
-
-		return nil, err
-	}
-
-	klog.Infof("created symlink from %s -> %s", vPodDirName, pPodDirName)
-	return &vPodDirName, nil
+// createPodLogSymlinkToPhysical links the virtual pod log dir to the physical
+// one and returns whether the symlink was newly created
+func createPodLogSymlinkToPhysical(ctx context.Context, vPodDirName, pPodDirName string) (bool, error) {
+	return ensureSymlink(ctx, SymlinkKindPodLog, pPodDirName, vPodDirName)
 }
@@ -2,12 +2,17 @@ package hostpaths
 
 import (
 	"context"
+	"maps"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"gotest.tools/assert"
 	"gotest.tools/assert/cmp"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
 func Test_filter(t *testing.T) {
@@ -81,3 +86,443 @@ func Test_filter(t *testing.T) {
 		)
 	}
 }
+
+func Test_mapperPath(t *testing.T) {
+	virtualPodLogsPath := t.TempDir()
+	options := &VirtualClusterOptions{VirtualPodLogsPath: virtualPodLogsPath}
+
+	err := os.Symlink("/var/vcluster/physical/log/pods/ns_pod-x-default-x-vcluster_uid2", filepath.Join(virtualPodLogsPath, "default_pod_uid1"))
+	assert.NilError(t, err)
+
+	testCases := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{
+			name:     "Physical pod log dir",
+			target:   "/var/vcluster/physical/log/pods/ns_pod-x-default-x-vcluster_uid2",
+			expected: "/var/log/pods/ns_pod-x-default-x-vcluster_uid2",
+		},
+		{
+			name:     "Container log file of a mapped virtual pod",
+			target:   "/var/log/pods/default_pod_uid1/container/0.log",
+			expected: "/var/log/pods/ns_pod-x-default-x-vcluster_uid2/container/0.log",
+		},
+		{
+			name:     "Container log file of an unmapped virtual pod",
+			target:   "/var/log/pods/default_other_uid3/container/0.log",
+			expected: filepath.Join(virtualPodLogsPath, "default_other_uid3/container/0.log"),
+		},
+		{
+			name:     "Kubelet pod dir",
+			target:   "/var/vcluster/physical/kubelet/pods/uid2/volumes",
+			expected: "/var/vcluster/physical/kubelet/pods/uid2/volumes",
+		},
+	}
+
+	for _, testCase := range testCases {
+		actual := mapperPath(options, testCase.target)
+		assert.Equal(t, actual, testCase.expected, "Unexpected result in test case %s", testCase.name)
+	}
+}
+
+func Test_cleanupOldContainerPaths(t *testing.T) {
+	options := &VirtualClusterOptions{VirtualLogsPath: t.TempDir(), VirtualContainerLogsPath: t.TempDir()}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+	t.Cleanup(func() { ownership.remove(vClusterKey(options)) })
+
+	links := map[string]bool{
+		"pod_default_app-123.log":      true,
+		"pod_default_init-456.log":     true,
+		"pod_default_debugger-789.log": false,
+		"pod_default_sidecar-a-1.log":  true,
+		"pod_default_app-100.log":      true,
+		"pod_default_app-50.log":       false,
+		"gone_default_app-123.log":     false,
+		"not-a-container-log":          true,
+	}
+	for link := range links {
+		assert.NilError(t, os.Symlink("/var/log/pods/target", filepath.Join(options.VirtualContainerLogsPath, link)))
+	}
+
+	// not created by the mapper
+	links["foreign_default_app-1.log"] = true
+	assert.NilError(t, os.Symlink("/opt/logs/app.log", filepath.Join(options.VirtualContainerLogsPath, "foreign_default_app-1.log")))
+
+	existingVPodsWithNS := map[string]map[string]bool{
+		"pod_default": {"app-123": true, "app-100": true, "init-456": true, "sidecar-a-1": true},
+	}
+	// the existing mapper links are adopted, as there is no ownership state
+	ownership.remove(vClusterKey(options))
+	plan := newCleanupPlan(newRetentionState(), ownership.forVCluster(options))
+	assert.NilError(t, cleanupOldContainerPaths(ctx, plan, existingVPodsWithNS))
+	assert.Equal(t, plan.total, len(links))
+	assert.Equal(t, plan.foreign, 1)
+	plan.remove(ctx)
+
+	for link, expectedKept := range links {
+		_, err := os.Lstat(filepath.Join(options.VirtualContainerLogsPath, link))
+		assert.Equal(t, err == nil, expectedKept, "Unexpected state of symlink %s: %v", link, err)
+	}
+}
+
+func Test_parseContainerSymlinkName(t *testing.T) {
+	testCases := []struct {
+		name              string
+		expectedPod       string
+		expectedNamespace string
+		expectedContainer string
+		expectedID        string
+		expectedOK        bool
+	}{
+		{
+			name:              "pod_default_app-123.log",
+			expectedPod:       "pod",
+			expectedNamespace: "default",
+			expectedContainer: "app",
+			expectedID:        "123",
+			expectedOK:        true,
+		},
+		{
+			name:              "pod-a_default_side-car-abc.log",
+			expectedPod:       "pod-a",
+			expectedNamespace: "default",
+			expectedContainer: "side-car",
+			expectedID:        "abc",
+			expectedOK:        true,
+		},
+		{
+			name: "pod_default_app.log",
+		},
+		{
+			name: "pod_default_app-123",
+		},
+		{
+			name: "lost+found",
+		},
+	}
+
+	for _, testCase := range testCases {
+		pod, namespace, container, id, ok := parseContainerSymlinkName(testCase.name)
+		assert.Equal(t, ok, testCase.expectedOK, "Unexpected result in test case %s", testCase.name)
+		assert.Equal(t, pod, testCase.expectedPod, "Unexpected pod in test case %s", testCase.name)
+		assert.Equal(t, namespace, testCase.expectedNamespace, "Unexpected namespace in test case %s", testCase.name)
+		assert.Equal(t, container, testCase.expectedContainer, "Unexpected container in test case %s", testCase.name)
+		assert.Equal(t, id, testCase.expectedID, "Unexpected container id in test case %s", testCase.name)
+	}
+}
+
+func Test_containerInstanceIDs(t *testing.T) {
+	testCases := []struct {
+		name            string
+		containerStatus corev1.ContainerStatus
+		expected        []string
+	}{
+		{
+			name:            "Waiting container",
+			containerStatus: corev1.ContainerStatus{Name: "app"},
+			expected:        []string{},
+		},
+		{
+			name:            "Running container",
+			containerStatus: corev1.ContainerStatus{Name: "app", ContainerID: "containerd://123"},
+			expected:        []string{"123"},
+		},
+		{
+			name: "Restarted container",
+			containerStatus: corev1.ContainerStatus{
+				Name:        "app",
+				ContainerID: "containerd://123",
+				LastTerminationState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ContainerID: "containerd://100"},
+				},
+			},
+			expected: []string{"123", "100"},
+		},
+		{
+			name: "Crashed container waiting for restart",
+			containerStatus: corev1.ContainerStatus{
+				Name: "app",
+				LastTerminationState: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ContainerID: "containerd://100"},
+				},
+			},
+			expected: []string{"100"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		actual := containerInstanceIDs(testCase.containerStatus)
+		assert.DeepEqual(t, actual, testCase.expected)
+	}
+}
+
+func Test_syncHostPaths(t *testing.T) {
+	// mappedPaths are the virtual paths of a mapped pod of the test host
+	mappedPaths := func(name string) map[string]string {
+		return map[string]string{
+			"log/pods/default_" + name + "_uid-" + name:       "/var/vcluster/physical/log/pods/vcluster-ns_" + name + "-x-default-x-vcluster_p-uid-" + name,
+			"log/containers/" + name + "_default_app-123.log": "/var/log/pods/default_" + name + "_uid-" + name + "/app/0.log",
+			"kubelet/pods/uid-" + name:                        "",
+			"kubelet/pods/uid-" + name + "/volumes":           "/var/vcluster/physical/kubelet/pods/p-uid-" + name + "/volumes",
+		}
+	}
+	merge := func(pathMaps ...map[string]string) map[string]string {
+		merged := map[string]string{}
+		for _, paths := range pathMaps {
+			maps.Copy(merged, paths)
+		}
+
+		return merged
+	}
+
+	testCases := []struct {
+		name string
+		// setup prepares the host and returns the virtual and physical pods
+		// of the resync
+		setup    func(h *testHost) ([]corev1.Pod, []corev1.Pod)
+		cleanup  bool
+		expected map[string]string
+	}{
+		{
+			name: "Links of a new pod",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  true,
+			expected: mappedPaths("web"),
+		},
+		{
+			name: "Links to a previous physical pod are repaired",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				assert.NilError(h.t, os.Symlink("/var/vcluster/physical/log/pods/vcluster-ns_web-x-default-x-vcluster_p-uid-old", filepath.Join(h.options.VirtualPodLogsPath, "default_web_uid-web")))
+				assert.NilError(h.t, os.MkdirAll(filepath.Join(h.options.VirtualKubeletPodPath, "uid-web"), 0755))
+				assert.NilError(h.t, os.Symlink("/var/vcluster/physical/kubelet/pods/p-uid-old/volumes", filepath.Join(h.options.VirtualKubeletPodPath, "uid-web", "volumes")))
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  true,
+			expected: mappedPaths("web"),
+		},
+		{
+			name: "Pods without physical pod or log directory are not mapped",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				pendingVPod, pendingPPod := h.addPod("pending")
+				h.removePodLogs(pendingPPod)
+				unscheduledVPod, _ := h.addPod("unscheduled")
+				return []corev1.Pod{vPod, pendingVPod, unscheduledVPod}, []corev1.Pod{pPod, pendingPPod}
+			},
+			cleanup:  true,
+			expected: mappedPaths("web"),
+		},
+		{
+			name: "Paths of a removed pod are removed once its physical files are gone",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				h.removePodLogs(gonePPod)
+				h.removeKubeletDir(gonePPod)
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  true,
+			expected: mappedPaths("web"),
+		},
+		{
+			name: "Paths of a removed pod are retained while its physical files exist",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  true,
+			expected: merge(mappedPaths("web"), mappedPaths("gone")),
+		},
+		{
+			name: "Kubelet directory of a removed pod is kept for velero while the physical one exists",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				h.removePodLogs(gonePPod)
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup: true,
+			expected: merge(mappedPaths("web"), map[string]string{
+				"kubelet/pods/uid-gone":         "",
+				"kubelet/pods/uid-gone/volumes": "/var/vcluster/physical/kubelet/pods/p-uid-gone/volumes",
+			}),
+		},
+		{
+			name: "Paths of a removed pod are removed after the retention period",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				h.options.RetentionPeriod = 0
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  true,
+			expected: mappedPaths("web"),
+		},
+		{
+			name: "Kubelet directory of a removed pod is kept for velero after the retention period if enabled",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				h.options.RetentionPeriod = 0
+				h.options.RetainKubeletPaths = true
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup: true,
+			expected: merge(mappedPaths("web"), map[string]string{
+				"kubelet/pods/uid-gone":         "",
+				"kubelet/pods/uid-gone/volumes": "/var/vcluster/physical/kubelet/pods/p-uid-gone/volumes",
+			}),
+		},
+		{
+			name: "Nothing is removed before the pod caches synced",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				goneVPod, gonePPod := h.addPod("gone")
+				h.sync([]corev1.Pod{vPod, goneVPod}, []corev1.Pod{pPod, gonePPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				h.removePodLogs(gonePPod)
+				h.removeKubeletDir(gonePPod)
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup:  false,
+			expected: merge(mappedPaths("web"), mappedPaths("gone")),
+		},
+		{
+			name: "Paths the mapper did not create are kept",
+			setup: func(h *testHost) ([]corev1.Pod, []corev1.Pod) {
+				vPod, pPod := h.addPod("web")
+				h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+				assert.NilError(h.t, os.Symlink("/opt/logs/app.log", filepath.Join(h.options.VirtualContainerLogsPath, "foreign_default_app-1.log")))
+				return []corev1.Pod{vPod}, []corev1.Pod{pPod}
+			},
+			cleanup: true,
+			expected: merge(mappedPaths("web"), map[string]string{
+				"log/containers/foreign_default_app-1.log": "/opt/logs/app.log",
+			}),
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			host := newTestHost(t)
+			vPods, pPods := testCase.setup(host)
+			host.sync(vPods, pPods, testCase.cleanup, newMapRetries(newPodEvents(host.options, nil)))
+			assert.DeepEqual(t, host.virtualPaths(), testCase.expected)
+		})
+	}
+}
+
+func Test_syncHostPathsMappingFailure(t *testing.T) {
+	host := newTestHost(t)
+	host.options.host.fs = &faultyFS{hostFS: osFS{}, failPath: "broken"}
+
+	vPod, pPod := host.addPod("web")
+	brokenVPod, brokenPPod := host.addPod("broken")
+
+	retries := newMapRetries(newPodEvents(host.options, nil))
+	retries.events = make(chan event.GenericEvent, 1)
+	host.sync([]corev1.Pod{brokenVPod, vPod}, []corev1.Pod{brokenPPod, pPod}, true, retries)
+
+	// the broken pod is retried and the other pods are mapped nonetheless
+	assert.Equal(t, len(retries.events), 1)
+	retried := <-retries.events
+	assert.Equal(t, retried.Object.GetName(), "broken")
+
+	paths := host.virtualPaths()
+	assert.Equal(t, paths["log/pods/default_web_uid-web"], "/var/vcluster/physical/log/pods/vcluster-ns_web-x-default-x-vcluster_p-uid-web")
+	_, ok := paths["log/pods/default_broken_uid-broken"]
+	assert.Assert(t, !ok)
+}
+
+func Test_validateOptions(t *testing.T) {
+	testCases := []struct {
+		name        string
+		modify      func(options *VirtualClusterOptions)
+		expectedErr string
+	}{
+		{
+			name:   "Defaults",
+			modify: func(options *VirtualClusterOptions) {},
+		},
+		{
+			name:   "Central",
+			modify: func(options *VirtualClusterOptions) { options.Central = true },
+		},
+		{
+			name: "Central with server",
+			modify: func(options *VirtualClusterOptions) {
+				options.Central = true
+				options.VirtualServer = "https://10.0.0.1"
+			},
+			expectedErr: "can't be used with --central",
+		},
+		{
+			name: "Central with kubeconfig secret",
+			modify: func(options *VirtualClusterOptions) {
+				options.Central = true
+				options.VirtualKubeConfigSecret = "vc-vcluster"
+			},
+			expectedErr: "can't be used with --central",
+		},
+		{
+			name: "Kubeconfig file and secret",
+			modify: func(options *VirtualClusterOptions) {
+				options.VirtualKubeConfig = "/data/kubeconfig"
+				options.VirtualKubeConfigSecret = "vc-vcluster"
+			},
+			expectedErr: "only one of",
+		},
+		{
+			name:        "Liveness threshold within the resync interval",
+			modify:      func(options *VirtualClusterOptions) { options.HealthProbeBindAddress = ":8081" },
+			expectedErr: "liveness threshold",
+		},
+		{
+			name: "Probe failure fraction of 0",
+			modify: func(options *VirtualClusterOptions) {
+				options.HealthProbeBindAddress = ":8081"
+				options.LivenessThreshold = 10 * time.Minute
+				options.ProbeFailureFraction = 0
+			},
+			expectedErr: "probe failure fraction",
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			options := &VirtualClusterOptions{
+				RestartConcurrency:     1,
+				DryRunOutput:           DryRunOutputLog,
+				ResyncInterval:         time.Minute,
+				MaxDeletionFraction:    0.5,
+				DeletionConfirmations:  3,
+				HealthProbeBindAddress: "0",
+				ReadinessThreshold:     5 * time.Minute,
+				LivenessThreshold:      time.Minute,
+				ProbeFailureFraction:   1,
+			}
+			testCase.modify(options)
+
+			err := validateOptions(options)
+			if testCase.expectedErr != "" {
+				assert.ErrorContains(t, err, testCase.expectedErr)
+				return
+			}
+
+			assert.NilError(t, err)
+		})
+	}
+}
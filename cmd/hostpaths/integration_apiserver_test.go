@@ -0,0 +1,186 @@
+//go:build integration
+
+package hostpaths
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	// assetsEnvVar points to the directory with the etcd and kube-apiserver
+	// binaries, the same variable envtest uses
+	assetsEnvVar = "KUBEBUILDER_ASSETS"
+
+	testAPIServerToken = "integration-test-token"
+
+	testAPIServerStartTimeout = time.Minute
+)
+
+// testAPIServer is an etcd and kube-apiserver pair that stands in for a host
+// or virtual cluster. There is no controller manager, scheduler or kubelet,
+// the tests create what these would.
+type testAPIServer struct {
+	config *rest.Config
+	client kubernetes.Interface
+}
+
+// findTestBinary looks up a control plane binary in the assets directory
+// and falls back to the PATH. A missing binary only skips the test locally,
+// in CI or with the assets directory set it fails, so a broken setup is not
+// mistaken for passing tests.
+func findTestBinary(t *testing.T, name string) string {
+	assets := os.Getenv(assetsEnvVar)
+	if assets != "" {
+		path := filepath.Join(assets, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	path, err := exec.LookPath(name)
+	if err == nil {
+		return path
+	}
+
+	message := fmt.Sprintf("%s not found, set %s to the directory of the envtest binaries, e.g. with setup-envtest", name, assetsEnvVar)
+	if assets != "" || os.Getenv("CI") != "" {
+		t.Fatal(message)
+	}
+
+	t.Skip(message)
+	return ""
+}
+
+// freePort returns a port on localhost that is currently not in use
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startTestProcess runs the binary until the test finished
+func startTestProcess(t *testing.T, name string, path string, args ...string) {
+	logFile, err := os.Create(filepath.Join(t.TempDir(), name+".log"))
+	assert.NilError(t, err)
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	assert.NilError(t, cmd.Start())
+
+	t.Cleanup(func() {
+		_ = cmd.Process.Signal(os.Interrupt)
+		done := make(chan struct{})
+		go func() {
+			_ = cmd.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			_ = cmd.Process.Kill()
+			<-done
+		}
+
+		if t.Failed() {
+			output, _ := os.ReadFile(logFile.Name())
+			t.Logf("output of %s:\n%s", name, output)
+		}
+		_ = logFile.Close()
+	})
+}
+
+// startTestAPIServer starts an etcd and a kube-apiserver that authenticates
+// requests with a static admin token
+func startTestAPIServer(t *testing.T, name string) *testAPIServer {
+	etcdPath := findTestBinary(t, "etcd")
+	apiServerPath := findTestBinary(t, "kube-apiserver")
+	dir := t.TempDir()
+
+	etcdURL := "http://127.0.0.1:" + strconv.Itoa(freePort(t))
+	startTestProcess(t, name+"-etcd", etcdPath,
+		"--data-dir="+filepath.Join(dir, "etcd"),
+		"--listen-client-urls="+etcdURL,
+		"--advertise-client-urls="+etcdURL,
+		"--listen-peer-urls=http://127.0.0.1:0",
+		"--unsafe-no-fsync=true",
+	)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NilError(t, err)
+	serviceAccountKey := filepath.Join(dir, "sa.key")
+	assert.NilError(t, os.WriteFile(serviceAccountKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+	tokenFile := filepath.Join(dir, "tokens.csv")
+	assert.NilError(t, os.WriteFile(tokenFile, []byte(testAPIServerToken+`,admin,admin,"system:masters"`+"\n"), 0600))
+
+	port := freePort(t)
+	startTestProcess(t, name+"-kube-apiserver", apiServerPath,
+		"--etcd-servers="+etcdURL,
+		"--cert-dir="+filepath.Join(dir, "certs"),
+		"--bind-address=127.0.0.1",
+		"--advertise-address=127.0.0.1",
+		"--secure-port="+strconv.Itoa(port),
+		"--service-cluster-ip-range=10.0.0.0/24",
+		"--service-account-issuer=https://"+name+".local",
+		"--service-account-key-file="+serviceAccountKey,
+		"--service-account-signing-key-file="+serviceAccountKey,
+		"--token-auth-file="+tokenFile,
+		"--authorization-mode=RBAC",
+		// there is no controller manager to create service account tokens
+		"--disable-admission-plugins=ServiceAccount",
+		"--allow-privileged=true",
+	)
+
+	config := &rest.Config{
+		Host:            fmt.Sprintf("https://127.0.0.1:%d", port),
+		BearerToken:     testAPIServerToken,
+		TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+	}
+	client, err := kubernetes.NewForConfig(config)
+	assert.NilError(t, err)
+
+	// the default namespace is created by the api server after it started
+	err = wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, testAPIServerStartTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := client.CoreV1().Namespaces().Get(ctx, "default", metav1.GetOptions{})
+		return err == nil, nil
+	})
+	assert.NilError(t, err, "%s api server did not become ready", name)
+
+	return &testAPIServer{config: config, client: client}
+}
+
+// writeKubeconfig writes a kubeconfig for the api server and returns its path
+func (s *testAPIServer) writeKubeconfig(t *testing.T) string {
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters["test"] = &clientcmdapi.Cluster{Server: s.config.Host, InsecureSkipTLSVerify: true}
+	kubeconfig.AuthInfos["test"] = &clientcmdapi.AuthInfo{Token: s.config.BearerToken}
+	kubeconfig.Contexts["test"] = &clientcmdapi.Context{Cluster: "test", AuthInfo: "test"}
+	kubeconfig.CurrentContext = "test"
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.NilError(t, clientcmd.WriteToFile(*kubeconfig, path))
+	return path
+}
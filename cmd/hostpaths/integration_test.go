@@ -0,0 +1,279 @@
+//go:build integration
+
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testNodeName = "node-a"
+
+	// eventuallyTimeout is how long a test waits for the mapper, which
+	// resyncs every second
+	eventuallyTimeout = 30 * time.Second
+)
+
+// testKubelet creates the files kubelet and the container runtime create for
+// the physical pods of a node, in a temp dir instead of the node's paths
+type testKubelet struct {
+	t      *testing.T
+	layout *hostLayout
+}
+
+func newTestKubelet(t *testing.T) *testKubelet {
+	root := t.TempDir()
+	layout := &hostLayout{
+		fs:                osFS{},
+		containerLogsPath: filepath.Join(root, "var", "log", "containers"),
+		podLogsPath:       filepath.Join(root, "var", "log", "pods"),
+		kubeletPodsPath:   filepath.Join(root, "var", "lib", "kubelet", "pods"),
+		virtualPath:       filepath.Join(root, "tmp", "vcluster"),
+	}
+
+	for _, path := range []string{layout.podLogsPath, layout.containerLogsPath, layout.kubeletPodsPath} {
+		assert.NilError(t, os.MkdirAll(path, 0755))
+	}
+
+	return &testKubelet{t: t, layout: layout}
+}
+
+func (k *testKubelet) podLogDir(pPod *corev1.Pod) string {
+	return filepath.Join(k.layout.podLogsPath, fmt.Sprintf("%s_%s_%s", pPod.Namespace, pPod.Name, pPod.UID))
+}
+
+func (k *testKubelet) containerLogLink(pPod *corev1.Pod, container, containerID string) string {
+	return filepath.Join(k.layout.containerLogsPath, fmt.Sprintf(ContainerSymlinkSourceTemplate, pPod.Name, pPod.Namespace, container, containerID))
+}
+
+// startPod creates the log and kubelet directories of the physical pod
+func (k *testKubelet) startPod(pPod *corev1.Pod) {
+	assert.NilError(k.t, os.MkdirAll(k.podLogDir(pPod), 0755))
+	assert.NilError(k.t, os.MkdirAll(filepath.Join(k.layout.kubeletPodsPath, string(pPod.UID), "volumes"), 0755))
+	assert.NilError(k.t, os.WriteFile(filepath.Join(k.layout.kubeletPodsPath, string(pPod.UID), "etc-hosts"), nil, 0644))
+}
+
+// startContainer creates the log file of a container instance and links it
+// in /var/log/containers, restartCount is the number of the instance
+func (k *testKubelet) startContainer(pPod *corev1.Pod, container, containerID string, restartCount int) {
+	logFile := filepath.Join(k.podLogDir(pPod), container, strconv.Itoa(restartCount)+".log")
+	assert.NilError(k.t, os.MkdirAll(filepath.Dir(logFile), 0755))
+	assert.NilError(k.t, os.WriteFile(logFile, []byte("started\n"), 0644))
+
+	// the links point to the paths on the node, like the ones of kubelet
+	target := filepath.Join(PodLogsMountPath, filepath.Base(k.podLogDir(pPod)), container, filepath.Base(logFile))
+	assert.NilError(k.t, os.Symlink(target, k.containerLogLink(pPod, container, containerID)))
+}
+
+// removeContainer removes the logs of a container instance, which kubelet
+// does once it is neither the current nor the last terminated instance
+func (k *testKubelet) removeContainer(pPod *corev1.Pod, container, containerID string, restartCount int) {
+	assert.NilError(k.t, os.Remove(filepath.Join(k.podLogDir(pPod), container, strconv.Itoa(restartCount)+".log")))
+	assert.NilError(k.t, os.Remove(k.containerLogLink(pPod, container, containerID)))
+}
+
+// removePod removes everything kubelet created for the physical pod
+func (k *testKubelet) removePod(pPod *corev1.Pod, containerIDs map[string][]string) {
+	for container, ids := range containerIDs {
+		for _, id := range ids {
+			_ = os.Remove(k.containerLogLink(pPod, container, id))
+		}
+	}
+
+	assert.NilError(k.t, os.RemoveAll(k.podLogDir(pPod)))
+	assert.NilError(k.t, os.RemoveAll(filepath.Join(k.layout.kubeletPodsPath, string(pPod.UID))))
+}
+
+// eventually waits until the condition is met
+func eventually(t *testing.T, description string, condition func() bool) {
+	t.Helper()
+
+	err := wait.PollUntilContextTimeout(context.Background(), 100*time.Millisecond, eventuallyTimeout, true, func(context.Context) (bool, error) {
+		return condition(), nil
+	})
+	assert.NilError(t, err, "timed out waiting until %s", description)
+}
+
+func readlink(path string) string {
+	target, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+
+	return target
+}
+
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// setContainerStatus reports the current and last terminated instance of
+// the container of the pod, like kubelet does
+func setContainerStatus(ctx context.Context, t *testing.T, kubeClient kubernetes.Interface, pod *corev1.Pod, containerID, lastContainerID string, restartCount int32) *corev1.Pod {
+	pod, err := kubeClient.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+	assert.NilError(t, err)
+
+	status := corev1.ContainerStatus{
+		Name:         "app",
+		Image:        "nginx",
+		ContainerID:  "containerd://" + containerID,
+		RestartCount: restartCount,
+		State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.Now()}},
+	}
+	if lastContainerID != "" {
+		status.LastTerminationState.Terminated = &corev1.ContainerStateTerminated{ExitCode: 1, ContainerID: "containerd://" + lastContainerID}
+	}
+
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{status}
+	pod, err = kubeClient.CoreV1().Pods(pod.Namespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	assert.NilError(t, err)
+
+	return pod
+}
+
+func Test_integration(t *testing.T) {
+	ctx := context.Background()
+	host := startTestAPIServer(t, "host")
+	virtual := startTestAPIServer(t, "virtual")
+	kubelet := newTestKubelet(t)
+
+	// the namespace and service account the controller manager and the
+	// syncer of the vCluster would create
+	_, err := host.client.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-ns"}}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+	_, err = virtual.client.CoreV1().ServiceAccounts("default").Create(ctx, &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "default"}}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+
+	t.Setenv("KUBECONFIG", host.writeKubeconfig(t))
+	t.Setenv("NAMESPACE", "vcluster-ns")
+	t.Setenv(HostpathMapperSelfNodeNameEnvVar, testNodeName)
+	t.Setenv(PodNameEnv, "hostpath-mapper")
+
+	options := &VirtualClusterOptions{
+		ResyncInterval:         time.Second,
+		MaxDeletionFraction:    1,
+		DeletionConfirmations:  1,
+		MetricsBindAddress:     "0",
+		HealthProbeBindAddress: "0",
+		RestartConcurrency:     1,
+		DryRunOutput:           DryRunOutputLog,
+		host:                   kubelet.layout,
+		VirtualKubeConfig:      virtual.writeKubeconfig(t),
+	}
+	options.Name = "vcluster"
+
+	mapperCtx, cancel := context.WithCancel(ctx)
+	mapperErr := make(chan error, 1)
+	go func() {
+		mapperErr <- Start(mapperCtx, options, false)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NilError(t, <-mapperErr)
+		ownership.remove(vClusterKey(options))
+		health.remove(vClusterKey(options))
+	})
+
+	virtualPath := kubelet.layout.vClusterPath("vcluster-ns", "vcluster")
+	eventually(t, "the virtual paths are created", func() bool {
+		return pathExists(filepath.Join(virtualPath, "log", "pods"))
+	})
+
+	// the virtual pod is scheduled and synced to the host cluster
+	vPod, err := virtual.client.CoreV1().Pods("default").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName:   testNodeName,
+			Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+		},
+	}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+
+	pPod, err := host.client.CoreV1().Pods("vcluster-ns").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web-x-default-x-vcluster",
+			Namespace: "vcluster-ns",
+			Labels:    map[string]string{translate.MarkerLabel: "vcluster"},
+			Annotations: map[string]string{
+				translate.NameAnnotation:      vPod.Name,
+				translate.NamespaceAnnotation: vPod.Namespace,
+				translate.UIDAnnotation:       string(vPod.UID),
+			},
+		},
+		Spec: corev1.PodSpec{
+			NodeName:   testNodeName,
+			Containers: []corev1.Container{{Name: "app", Image: "nginx"}},
+		},
+	}, metav1.CreateOptions{})
+	assert.NilError(t, err)
+
+	eventually(t, "the missing log directory is reported", func() bool {
+		events, err := virtual.client.CoreV1().Events("default").List(ctx, metav1.ListOptions{})
+		assert.NilError(t, err)
+		for _, event := range events.Items {
+			if event.InvolvedObject.UID == vPod.UID && event.Reason == EventReasonLogDirectoryNotFound {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	// kubelet starts the pod
+	kubelet.startPod(pPod)
+	kubelet.startContainer(pPod, "app", "c1", 0)
+	vPod = setContainerStatus(ctx, t, virtual.client, vPod, "c1", "", 0)
+
+	podLogLink := filepath.Join(virtualPath, "log", "pods", fmt.Sprintf("default_web_%s", vPod.UID))
+	kubeletPodDir := filepath.Join(virtualPath, "kubelet", "pods", string(vPod.UID))
+	containerLink := func(containerID string) string {
+		return filepath.Join(virtualPath, "log", "containers", "web_default_app-"+containerID+".log")
+	}
+	eventually(t, "the pod is mapped", func() bool {
+		return readlink(podLogLink) == fmt.Sprintf("/var/vcluster/physical/log/pods/vcluster-ns_web-x-default-x-vcluster_%s", pPod.UID) &&
+			readlink(filepath.Join(kubeletPodDir, "volumes")) == fmt.Sprintf("/var/vcluster/physical/kubelet/pods/%s/volumes", pPod.UID) &&
+			readlink(filepath.Join(kubeletPodDir, "etc-hosts")) == fmt.Sprintf("/var/vcluster/physical/kubelet/pods/%s/etc-hosts", pPod.UID) &&
+			readlink(containerLink("c1")) == fmt.Sprintf("/var/log/pods/default_web_%s/app/0.log", vPod.UID)
+	})
+
+	// the container restarts, the logs of the last instance are kept
+	kubelet.startContainer(pPod, "app", "c2", 1)
+	vPod = setContainerStatus(ctx, t, virtual.client, vPod, "c2", "c1", 1)
+	eventually(t, "the restarted container is mapped", func() bool {
+		return readlink(containerLink("c2")) == fmt.Sprintf("/var/log/pods/default_web_%s/app/1.log", vPod.UID) &&
+			pathExists(containerLink("c1"))
+	})
+
+	// the container restarts again, the logs of the first instance are gone
+	kubelet.startContainer(pPod, "app", "c3", 2)
+	kubelet.removeContainer(pPod, "app", "c1", 0)
+	vPod = setContainerStatus(ctx, t, virtual.client, vPod, "c3", "c2", 2)
+	eventually(t, "the first container instance is cleaned up", func() bool {
+		return pathExists(containerLink("c3")) && pathExists(containerLink("c2")) && !pathExists(containerLink("c1"))
+	})
+
+	// the pod is deleted in both clusters and kubelet removes its files
+	deleteOptions := metav1.DeleteOptions{GracePeriodSeconds: ptr.To(int64(0))}
+	assert.NilError(t, virtual.client.CoreV1().Pods("default").Delete(ctx, vPod.Name, deleteOptions))
+	assert.NilError(t, host.client.CoreV1().Pods("vcluster-ns").Delete(ctx, pPod.Name, deleteOptions))
+	kubelet.removePod(pPod, map[string][]string{"app": {"c2", "c3"}})
+	eventually(t, "the paths of the deleted pod are cleaned up", func() bool {
+		return !pathExists(podLogLink) && !pathExists(kubeletPodDir) && !pathExists(containerLink("c2")) && !pathExists(containerLink("c3"))
+	})
+}
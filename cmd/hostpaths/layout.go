@@ -0,0 +1,135 @@
+package hostpaths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+	"github.com/spf13/pflag"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+// HostLayoutConfig are the paths the node's directories are mounted to in
+// the mapper. They only need to be changed if the mapper runs with other
+// mounts, e.g. outside of the chart. The symlinks the mapper creates are not
+// affected, as they point to the paths the pods of the vCluster see.
+type HostLayoutConfig struct {
+	ContainerLogsPath string `json:"containerLogsPath,omitempty"`
+	PodLogsPath       string `json:"podLogsPath,omitempty"`
+	KubeletPodsPath   string `json:"kubeletPodsPath,omitempty"`
+	VirtualPath       string `json:"virtualPath,omitempty"`
+}
+
+// addHostLayoutFlags adds the flags that override the roots the mapper reads
+// from and writes to
+func addHostLayoutFlags(flags *pflag.FlagSet, options *VirtualClusterOptions) {
+	flags.StringVar(&options.HostLayout.ContainerLogsPath, "container-logs-path", ContainerLogsMountPath, "The path the node's /var/log/containers is mounted to")
+	flags.StringVar(&options.HostLayout.PodLogsPath, "pod-logs-path", PodLogsMountPath, "The path the node's /var/log/pods is mounted to")
+	flags.StringVar(&options.HostLayout.KubeletPodsPath, "kubelet-pods-path", podtranslate.PhysicalKubeletVolumeMountPath, "The path the pods directory of the kubelet root dir of the node is mounted to")
+	flags.StringVar(&options.HostLayout.VirtualPath, "virtual-path", VirtualPathMountPath, "The path the node's "+VirtualPathMountPath+" is mounted to, which contains the virtual paths of the vClusters")
+	flags.StringVar(&options.HostLayoutFile, "host-layout-config", "", "A yaml file with the containerLogsPath, podLogsPath, kubeletPodsPath and virtualPath, flags that are set take precedence")
+}
+
+// setupHostLayout applies the host layout config file and the flags to the
+// options. Values of the file are only used for flags that were not set.
+func setupHostLayout(flags *pflag.FlagSet, options *VirtualClusterOptions) error {
+	if options.HostLayoutFile != "" {
+		fileConfig, err := loadHostLayoutConfig(options.HostLayoutFile)
+		if err != nil {
+			return err
+		}
+
+		for flag, value := range map[string]string{
+			"container-logs-path": fileConfig.ContainerLogsPath,
+			"pod-logs-path":       fileConfig.PodLogsPath,
+			"kubelet-pods-path":   fileConfig.KubeletPodsPath,
+			"virtual-path":        fileConfig.VirtualPath,
+		} {
+			if value != "" && !flags.Changed(flag) {
+				err = flags.Set(flag, value)
+				if err != nil {
+					return fmt.Errorf("set %s from %s: %w", flag, options.HostLayoutFile, err)
+				}
+			}
+		}
+	}
+
+	host, err := newHostLayout(options.HostLayout)
+	if err != nil {
+		return err
+	}
+
+	options.host = host
+	return nil
+}
+
+func loadHostLayoutConfig(path string) (*HostLayoutConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read host layout config: %w", err)
+	}
+
+	config := &HostLayoutConfig{}
+	err = yaml.UnmarshalStrict(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("parse host layout config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+type layoutRoot struct {
+	name string
+	path string
+
+	// optional roots only disable a part of the mapping if they are missing
+	optional bool
+}
+
+// newHostLayout validates the config and returns the layout of the node
+func newHostLayout(config HostLayoutConfig) (*hostLayout, error) {
+	for _, root := range []layoutRoot{
+		{name: "container logs path", path: config.ContainerLogsPath},
+		{name: "pod logs path", path: config.PodLogsPath},
+		{name: "kubelet pods path", path: config.KubeletPodsPath},
+		{name: "virtual path", path: config.VirtualPath},
+	} {
+		if !filepath.IsAbs(root.path) {
+			return nil, fmt.Errorf("%s %q needs to be an absolute path", root.name, root.path)
+		}
+	}
+
+	return &hostLayout{
+		fs:                osFS{},
+		containerLogsPath: filepath.Clean(config.ContainerLogsPath),
+		podLogsPath:       filepath.Clean(config.PodLogsPath),
+		kubeletPodsPath:   filepath.Clean(config.KubeletPodsPath),
+		virtualPath:       filepath.Clean(config.VirtualPath),
+	}, nil
+}
+
+// checkMounted verifies that the physical paths the mapper reads from exist.
+// Without the container logs path only the container log links are disabled.
+func (l *hostLayout) checkMounted() error {
+	for _, root := range []layoutRoot{
+		{name: "container logs path", path: l.containerLogsPath, optional: true},
+		{name: "pod logs path", path: l.podLogsPath},
+		{name: "kubelet pods path", path: l.kubeletPodsPath},
+	} {
+		info, err := l.fs.Stat(root.path)
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("%s is not a directory", root.path)
+		}
+
+		if err != nil && root.optional {
+			klog.Warningf("not mapping the container logs, %s: %v", root.name, err)
+			l.noContainerLogs = true
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", root.name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package hostpaths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+	"github.com/spf13/pflag"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_setupHostLayout(t *testing.T) {
+	testTable := []struct {
+		name string
+		file string
+		args []string
+
+		expected    HostLayoutConfig
+		expectedErr string
+	}{
+		{
+			name: "defaults",
+			expected: HostLayoutConfig{
+				ContainerLogsPath: ContainerLogsMountPath,
+				PodLogsPath:       PodLogsMountPath,
+				KubeletPodsPath:   podtranslate.PhysicalKubeletVolumeMountPath,
+				VirtualPath:       VirtualPathMountPath,
+			},
+		},
+		{
+			name: "flags",
+			args: []string{"--kubelet-pods-path=/var/lib/k0s/kubelet/pods/", "--virtual-path=/host/tmp/vcluster"},
+			expected: HostLayoutConfig{
+				ContainerLogsPath: ContainerLogsMountPath,
+				PodLogsPath:       PodLogsMountPath,
+				KubeletPodsPath:   "/var/lib/k0s/kubelet/pods",
+				VirtualPath:       "/host/tmp/vcluster",
+			},
+		},
+		{
+			name: "file and flags",
+			file: "podLogsPath: /host/var/log/pods\nkubeletPodsPath: /host/var/lib/kubelet/pods\n",
+			args: []string{"--kubelet-pods-path=/var/lib/k0s/kubelet/pods"},
+			expected: HostLayoutConfig{
+				ContainerLogsPath: ContainerLogsMountPath,
+				PodLogsPath:       "/host/var/log/pods",
+				KubeletPodsPath:   "/var/lib/k0s/kubelet/pods",
+				VirtualPath:       VirtualPathMountPath,
+			},
+		},
+		{
+			name:        "unknown field in file",
+			file:        "kubeletPath: /var/lib/k0s/kubelet/pods\n",
+			expectedErr: `unknown field "kubeletPath"`,
+		},
+		{
+			name:        "relative path",
+			args:        []string{"--pod-logs-path=var/log/pods"},
+			expectedErr: `pod logs path "var/log/pods" needs to be an absolute path`,
+		},
+	}
+
+	for _, testCase := range testTable {
+		t.Run(testCase.name, func(t *testing.T) {
+			options := &VirtualClusterOptions{}
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			addHostLayoutFlags(flags, options)
+
+			args := testCase.args
+			if testCase.file != "" {
+				path := filepath.Join(t.TempDir(), "layout.yaml")
+				assert.NilError(t, os.WriteFile(path, []byte(testCase.file), 0644))
+				args = append(args, "--host-layout-config="+path)
+			}
+			assert.NilError(t, flags.Parse(args))
+
+			err := setupHostLayout(flags, options)
+			if testCase.expectedErr != "" {
+				assert.ErrorContains(t, err, testCase.expectedErr)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, options.host.containerLogsPath, testCase.expected.ContainerLogsPath)
+			assert.Equal(t, options.host.podLogsPath, testCase.expected.PodLogsPath)
+			assert.Equal(t, options.host.kubeletPodsPath, testCase.expected.KubeletPodsPath)
+			assert.Equal(t, options.host.virtualPath, testCase.expected.VirtualPath)
+		})
+	}
+}
+
+func Test_checkMounted(t *testing.T) {
+	host := newTestHost(t)
+	layout := host.options.layout()
+	assert.NilError(t, layout.checkMounted())
+
+	assert.NilError(t, os.RemoveAll(layout.kubeletPodsPath))
+	assert.ErrorContains(t, layout.checkMounted(), "kubelet pods path")
+
+	assert.NilError(t, os.WriteFile(layout.kubeletPodsPath, nil, 0644))
+	assert.ErrorContains(t, layout.checkMounted(), "is not a directory")
+}
+
+func Test_checkMountedWithoutContainerLogs(t *testing.T) {
+	host := newTestHost(t)
+	layout := host.options.layout()
+	vPod, pPod := host.addPod("web")
+	assert.NilError(t, os.RemoveAll(layout.containerLogsPath))
+	assert.NilError(t, layout.checkMounted())
+	assert.Assert(t, layout.noContainerLogs)
+
+	// the pod log and kubelet paths are still mapped
+	host.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, true, newMapRetries(newPodEvents(host.options, nil)))
+	assert.DeepEqual(t, host.virtualPaths(), map[string]string{
+		"log/pods/default_web_uid-web": "/var/vcluster/physical/log/pods/vcluster-ns_web-x-default-x-vcluster_p-uid-web",
+		"kubelet/pods/uid-web":         "",
+		"kubelet/pods/uid-web/volumes": "/var/vcluster/physical/kubelet/pods/p-uid-web/volumes",
+	})
+}
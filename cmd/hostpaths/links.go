@@ -0,0 +1,115 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/klog/v2"
+)
+
+// ensureSymlink makes sure source is a symlink to target and returns
+// whether it had to be created. A symlink to another target, e.g. left over
+// from a previous physical pod of the same virtual pod, is replaced
+// atomically, so readers never see the link missing.
+func ensureSymlink(ctx context.Context, kind, target, source string) (bool, error) {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+
+	fsys := options.fs()
+	currentTarget, err := fsys.Readlink(source)
+	if err == nil && currentTarget == target {
+		if !options.DryRun {
+			ownership.forVCluster(options).add(source)
+		}
+
+		return false, nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("read symlink %s: %w", source, err)
+	}
+
+	exists := err == nil
+	if options.DryRun {
+		action := dryRunActionCreateSymlink
+		if exists {
+			action = dryRunActionReplaceSymlink
+		}
+
+		reportDryRun(options, dryRunAction{Action: action, Kind: kind, Path: source, Target: target})
+		return !exists, nil
+	}
+
+	if !exists {
+		err = fsys.Symlink(target, source)
+		if err != nil {
+			return false, err
+		}
+
+		ownership.forVCluster(options).add(source)
+		klog.Infof("created %s symlink %s -> %s", kind, source, target)
+		recordSymlinkCreated(ctx, kind)
+		return true, nil
+	}
+
+	// rename replaces the existing symlink in a single step
+	tmpSource := filepath.Join(filepath.Dir(source), "."+filepath.Base(source)+".tmp")
+	err = fsys.Remove(tmpSource)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("remove temporary symlink %s: %w", tmpSource, err)
+	}
+
+	err = fsys.Symlink(target, tmpSource)
+	if err != nil {
+		return false, fmt.Errorf("create temporary symlink %s: %w", tmpSource, err)
+	}
+
+	err = fsys.Rename(tmpSource, source)
+	if err != nil {
+		_ = fsys.Remove(tmpSource)
+		return false, fmt.Errorf("replace symlink %s: %w", source, err)
+	}
+
+	ownership.forVCluster(options).add(source)
+	klog.Infof("repaired %s symlink %s, pointed to %s instead of %s", kind, source, currentTarget, target)
+	recordSymlinkRepaired(ctx, kind)
+	return false, nil
+}
+
+// removePath removes path and everything below it
+func removePath(ctx context.Context, kind, path string) error {
+	options := ctx.Value(optionsKey).(*VirtualClusterOptions)
+	if options.DryRun {
+		reportDryRun(options, dryRunAction{Action: dryRunActionDelete, Kind: kind, Path: path})
+		return nil
+	}
+
+	klog.Infof("cleaning up %s", path)
+	err := options.fs().RemoveAll(path)
+	if err != nil {
+		return err
+	}
+
+	ownership.forVCluster(options).remove(path)
+
+	recordSymlinkRemoved(ctx, kind)
+	return nil
+}
+
+// makeDirectory creates path and its parents if they do not exist yet
+func makeDirectory(options *VirtualClusterOptions, path string) error {
+	if options.DryRun {
+		if _, err := options.fs().Stat(path); err != nil {
+			reportDryRun(options, dryRunAction{Action: dryRunActionCreateDirectory, Path: path})
+		}
+
+		return nil
+	}
+
+	err := options.fs().MkdirAll(path, os.ModeDir)
+	if err != nil {
+		return err
+	}
+
+	ownership.forVCluster(options).add(path)
+	return nil
+}
@@ -0,0 +1,59 @@
+package hostpaths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func Test_ensureSymlink(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+
+	oldTarget := filepath.Join(dir, "ns_pod_old-uid")
+	newTarget := filepath.Join(dir, "ns_pod_new-uid")
+	source := filepath.Join(dir, "default_pod_uid")
+
+	testCases := []struct {
+		name            string
+		target          string
+		expectedCreated bool
+	}{
+		{
+			name:            "Missing symlink",
+			target:          oldTarget,
+			expectedCreated: true,
+		},
+		{
+			name:            "Symlink with the expected target",
+			target:          oldTarget,
+			expectedCreated: false,
+		},
+		{
+			name:            "Symlink with a wrong target",
+			target:          newTarget,
+			expectedCreated: false,
+		},
+	}
+
+	for _, testCase := range testCases {
+		created, err := ensureSymlink(ctx, SymlinkKindPodLog, testCase.target, source)
+		assert.NilError(t, err, "Unexpected error in test case %s", testCase.name)
+		assert.Equal(t, created, testCase.expectedCreated, "Unexpected result in test case %s", testCase.name)
+
+		target, err := os.Readlink(source)
+		assert.NilError(t, err, "Unexpected error in test case %s", testCase.name)
+		assert.Equal(t, target, testCase.target, "Unexpected target in test case %s", testCase.name)
+	}
+
+	// the temporary symlink must not be left behind
+	entries, err := os.ReadDir(dir)
+	assert.NilError(t, err)
+	assert.Equal(t, len(entries), 1)
+}
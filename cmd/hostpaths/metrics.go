@@ -0,0 +1,251 @@
+package hostpaths
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	metricsNamespace = "vcluster_hostpath_mapper"
+
+	SymlinkKindPodLog       = "pod_log"
+	SymlinkKindContainerLog = "container_log"
+	SymlinkKindKubelet      = "kubelet"
+
+	reconcileTypePod    = "pod"
+	reconcileTypeResync = "resync"
+)
+
+var (
+	symlinksCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "symlinks_created_total",
+		Help:      "Number of symlinks created in the virtual paths per kind (pod_log, container_log, kubelet)",
+	}, []string{"vcluster", "kind"})
+
+	symlinksRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "symlinks_removed_total",
+		Help:      "Number of symlinks removed from the virtual paths per kind (pod_log, container_log, kubelet)",
+	}, []string{"vcluster", "kind"})
+
+	symlinksRepaired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "symlinks_repaired_total",
+		Help:      "Number of symlinks in the virtual paths that pointed to a wrong target and were replaced per kind (pod_log, container_log, kubelet)",
+	}, []string{"vcluster", "kind"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of mapping a single pod (pod) or all pods on the node (resync)",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	}, []string{"vcluster", "type"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_errors_total",
+		Help:      "Number of failed reconciles",
+	}, []string{"vcluster", "type"})
+
+	podMappingErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "pod_mapping_errors_total",
+		Help:      "Number of times the paths of a single virtual pod could not be mapped, the pod is retried with backoff",
+	}, []string{"vcluster"})
+
+	virtualPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "virtual_pods",
+		Help:      "Number of virtual pods on the node whose paths are mapped or not mapped (yet), as of the last resync",
+	}, []string{"vcluster", "state"})
+
+	danglingSymlinks = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "dangling_symlinks",
+		Help:      "Number of symlinks in the virtual paths whose target does not exist, as of the last resync",
+	}, []string{"vcluster"})
+
+	cleanupBlocked = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cleanup_blocked_total",
+		Help:      "Number of resyncs that skipped the cleanup because the pod caches had not synced (caches_not_synced) or it would have deleted too many paths at once (mass_deletion)",
+	}, []string{"vcluster", "reason"})
+
+	pendingDeletions = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "pending_deletions",
+		Help:      "Number of stale paths whose deletion is held back until it is confirmed by consecutive resyncs",
+	}, []string{"vcluster"})
+
+	foreignPaths = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "foreign_paths",
+		Help:      "Number of entries in the virtual paths that belong to no virtual pod but were not created by the mapper and are therefore kept, as of the last resync",
+	}, []string{"vcluster"})
+
+	credentialReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "credential_reloads_total",
+		Help:      "Number of times the mapper reconnected to the virtual cluster because its credentials changed",
+	}, []string{"vcluster"})
+
+	connected = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "connected",
+		Help:      "Whether the mapper is connected to the virtual cluster api server (1) or waiting for it (0)",
+	}, []string{"vcluster"})
+
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_success_timestamp_seconds",
+		Help:      "Unix time of the last reconcile that finished without an error, missing until the first one",
+	}, []string{"vcluster"})
+
+	logLinkLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "log_link_latency_seconds",
+		Help:      "Time from the start of a virtual pod until its pod log symlink was created",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"vcluster"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		symlinksCreated,
+		symlinksRemoved,
+		symlinksRepaired,
+		reconcileDuration,
+		reconcileErrors,
+		podMappingErrors,
+		virtualPods,
+		danglingSymlinks,
+		cleanupBlocked,
+		pendingDeletions,
+		foreignPaths,
+		credentialReloads,
+		connected,
+		lastSuccess,
+		logLinkLatency,
+	)
+}
+
+// vClusterLabel returns the value of the vcluster label for the vCluster
+// whose options are stored in the context
+func vClusterLabel(ctx context.Context) string {
+	return vClusterKey(ctx.Value(optionsKey).(*VirtualClusterOptions))
+}
+
+// vClusterKey identifies the vCluster in metrics and health checks
+func vClusterKey(options *VirtualClusterOptions) string {
+	return options.TargetNamespace + "/" + options.Name
+}
+
+func recordSymlinkCreated(ctx context.Context, kind string) {
+	symlinksCreated.WithLabelValues(vClusterLabel(ctx), kind).Inc()
+}
+
+func recordSymlinkRemoved(ctx context.Context, kind string) {
+	symlinksRemoved.WithLabelValues(vClusterLabel(ctx), kind).Inc()
+}
+
+func recordSymlinkRepaired(ctx context.Context, kind string) {
+	symlinksRepaired.WithLabelValues(vClusterLabel(ctx), kind).Inc()
+}
+
+func recordConnected(vCluster string, isConnected bool) {
+	value := 0.0
+	if isConnected {
+		value = 1
+	}
+
+	connected.WithLabelValues(vCluster).Set(value)
+}
+
+// recordLastSuccess sets the time of the last successful reconcile, a zero
+// time removes the series
+func recordLastSuccess(vCluster string, t time.Time) {
+	if t.IsZero() {
+		lastSuccess.DeleteLabelValues(vCluster)
+		return
+	}
+
+	lastSuccess.WithLabelValues(vCluster).Set(float64(t.UnixNano()) / float64(time.Second))
+}
+
+// deleteVClusterMetrics removes all series of a vCluster that is no longer
+// mapped by this process
+func deleteVClusterMetrics(vCluster string) {
+	labels := prometheus.Labels{"vcluster": vCluster}
+	symlinksCreated.DeletePartialMatch(labels)
+	symlinksRemoved.DeletePartialMatch(labels)
+	symlinksRepaired.DeletePartialMatch(labels)
+	reconcileDuration.DeletePartialMatch(labels)
+	reconcileErrors.DeletePartialMatch(labels)
+	podMappingErrors.DeletePartialMatch(labels)
+	virtualPods.DeletePartialMatch(labels)
+	danglingSymlinks.DeletePartialMatch(labels)
+	cleanupBlocked.DeletePartialMatch(labels)
+	pendingDeletions.DeletePartialMatch(labels)
+	foreignPaths.DeletePartialMatch(labels)
+	credentialReloads.DeletePartialMatch(labels)
+	connected.DeletePartialMatch(labels)
+	lastSuccess.DeletePartialMatch(labels)
+	logLinkLatency.DeletePartialMatch(labels)
+}
+
+// countDanglingSymlinks counts the symlinks in the virtual paths that do not
+// resolve
+func countDanglingSymlinks(options *VirtualClusterOptions) int {
+	fsys := options.fs()
+	paths := []string{}
+	for _, dir := range []string{options.VirtualPodLogsPath, options.VirtualContainerLogsPath} {
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			klog.Errorf("error reading %s: %v", dir, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	kubeletPodDirs, err := fsys.ReadDir(options.VirtualKubeletPodPath)
+	if err != nil {
+		klog.Errorf("error reading %s: %v", options.VirtualKubeletPodPath, err)
+	}
+
+	for _, kubeletPodDir := range kubeletPodDirs {
+		dir := filepath.Join(options.VirtualKubeletPodPath, kubeletPodDir.Name())
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, entry := range entries {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	dangling := 0
+	for _, path := range paths {
+		target, err := fsys.Readlink(path)
+		if err != nil {
+			// not a symlink
+			continue
+		}
+
+		_, err = fsys.Stat(mapperPath(options, target))
+		if err != nil {
+			dangling++
+		}
+	}
+
+	return dangling
+}
@@ -0,0 +1,227 @@
+package hostpaths
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+	"k8s.io/klog/v2"
+)
+
+// ownershipStateFilename is stored in the virtual root of the vCluster next
+// to the retention state
+const ownershipStateFilename = ".hostpath-mapper-owned.json"
+
+// ownership tracks the virtual paths each vCluster mapper created, only
+// these are garbage collected
+var ownership = &ownershipRegistry{vClusters: map[string]*ownedPaths{}}
+
+type ownershipRegistry struct {
+	m         sync.Mutex
+	vClusters map[string]*ownedPaths
+}
+
+// ownedPaths is the set of virtual paths a mapper created or maintains.
+// Entries of the virtual paths it does not own were placed there by an
+// operator or another tool and are reported instead of deleted.
+type ownedPaths struct {
+	m     sync.Mutex
+	fs    hostFS
+	file  string
+	paths map[string]bool
+	dirty bool
+
+	// reported are the foreign paths that were already logged
+	reported map[string]bool
+}
+
+// forVCluster returns the owned paths of the vCluster, which are loaded from
+// the virtual paths the first time
+func (r *ownershipRegistry) forVCluster(options *VirtualClusterOptions) *ownedPaths {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	key := vClusterKey(options)
+	if r.vClusters[key] == nil {
+		r.vClusters[key] = loadOwnedPaths(options)
+	}
+
+	return r.vClusters[key]
+}
+
+func (r *ownershipRegistry) remove(key string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.vClusters, key)
+}
+
+func ownershipStatePath(options *VirtualClusterOptions) string {
+	return filepath.Join(options.VirtualRootPath, ownershipStateFilename)
+}
+
+// loadOwnedPaths reads the persisted owned paths. Without a state, e.g.
+// after an update from a version that did not track them, the entries that
+// look like they were created by the mapper are adopted.
+func loadOwnedPaths(options *VirtualClusterOptions) *ownedPaths {
+	owned := &ownedPaths{
+		fs:       options.fs(),
+		file:     ownershipStatePath(options),
+		paths:    map[string]bool{},
+		reported: map[string]bool{},
+	}
+
+	data, err := owned.fs.ReadFile(owned.file)
+	if err == nil {
+		paths := []string{}
+		err = json.Unmarshal(data, &paths)
+		if err == nil {
+			for _, path := range paths {
+				owned.paths[path] = true
+			}
+
+			return owned
+		}
+
+		klog.Errorf("error parsing owned paths %s, adopting existing paths: %v", owned.file, err)
+	} else if !os.IsNotExist(err) {
+		klog.Errorf("error reading owned paths, adopting existing paths: %v", err)
+	}
+
+	for _, path := range adoptablePaths(options) {
+		owned.paths[path] = true
+		owned.dirty = true
+	}
+
+	return owned
+}
+
+// adoptablePaths returns the entries of the virtual paths that are symlinks to
+// the physical paths, or kubelet directories only containing such symlinks
+func adoptablePaths(options *VirtualClusterOptions) []string {
+	fsys := options.fs()
+	isMapperSymlink := func(path string) bool {
+		target, err := fsys.Readlink(path)
+		return err == nil && (strings.HasPrefix(target, podtranslate.PhysicalPodLogVolumeMountPath+"/") ||
+			strings.HasPrefix(target, podtranslate.PhysicalKubeletVolumeMountPath+"/") ||
+			strings.HasPrefix(target, PodLogsMountPath+"/"))
+	}
+
+	paths := []string{}
+	for _, dir := range []string{options.VirtualPodLogsPath, options.VirtualContainerLogsPath} {
+		for _, name := range readDirNames(fsys, dir) {
+			if path := filepath.Join(dir, name); isMapperSymlink(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+
+	for _, name := range readDirNames(fsys, options.VirtualKubeletPodPath) {
+		kubeletPodPath := filepath.Join(options.VirtualKubeletPodPath, name)
+		entries := readDirNames(fsys, kubeletPodPath)
+		if len(entries) == 0 {
+			continue
+		}
+
+		adoptable := true
+		for _, entry := range entries {
+			adoptable = adoptable && isMapperSymlink(filepath.Join(kubeletPodPath, entry))
+		}
+		if adoptable {
+			paths = append(paths, kubeletPodPath)
+		}
+	}
+
+	return paths
+}
+
+func (o *ownedPaths) add(path string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if !o.paths[path] {
+		o.paths[path] = true
+		o.dirty = true
+	}
+}
+
+// remove forgets path and everything below it
+func (o *ownedPaths) remove(path string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	for owned := range o.paths {
+		if owned == path || strings.HasPrefix(owned, path+"/") {
+			delete(o.paths, owned)
+			o.dirty = true
+		}
+	}
+}
+
+func (o *ownedPaths) owns(path string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	return o.paths[path]
+}
+
+// reportForeign logs a foreign path the first time it is found stale
+func (o *ownedPaths) reportForeign(path string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if !o.reported[path] {
+		o.reported[path] = true
+		klog.Infof("not cleaning up %s, it was not created by the hostpath mapper", path)
+	}
+}
+
+// saveOwnedPaths persists the owned paths of the vCluster if they changed
+func saveOwnedPaths(options *VirtualClusterOptions) error {
+	if options.DryRun {
+		return nil
+	}
+
+	return ownership.forVCluster(options).save()
+}
+
+func (o *ownedPaths) save() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if !o.dirty {
+		return nil
+	}
+
+	paths := make([]string, 0, len(o.paths))
+	for path := range o.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	data, err := json.Marshal(paths)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so a crash never leaves a truncated state behind
+	tmpFile := filepath.Join(filepath.Dir(o.file), "."+filepath.Base(o.file)+".tmp")
+	err = o.fs.WriteFile(tmpFile, data, 0600)
+	if err != nil {
+		return fmt.Errorf("write owned paths: %w", err)
+	}
+
+	err = o.fs.Rename(tmpFile, o.file)
+	if err != nil {
+		_ = o.fs.Remove(tmpFile)
+		return fmt.Errorf("replace owned paths: %w", err)
+	}
+
+	o.dirty = false
+	return nil
+}
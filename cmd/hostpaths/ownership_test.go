@@ -0,0 +1,89 @@
+package hostpaths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_ownedPaths(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{
+		VirtualRootPath:          dir,
+		VirtualLogsPath:          filepath.Join(dir, "log"),
+		VirtualPodLogsPath:       filepath.Join(dir, "log", "pods"),
+		VirtualContainerLogsPath: filepath.Join(dir, "log", "containers"),
+		VirtualKubeletPodPath:    filepath.Join(dir, "kubelet", "pods"),
+	}
+
+	for _, path := range []string{options.VirtualPodLogsPath, options.VirtualContainerLogsPath, filepath.Join(options.VirtualKubeletPodPath, "uid-a"), filepath.Join(options.VirtualKubeletPodPath, "uid-b")} {
+		assert.NilError(t, os.MkdirAll(path, 0755))
+	}
+
+	podLog := filepath.Join(options.VirtualPodLogsPath, "default_a_uid-a")
+	containerLog := filepath.Join(options.VirtualContainerLogsPath, "a_default_app-123.log")
+	kubeletPod := filepath.Join(options.VirtualKubeletPodPath, "uid-a")
+	foreignPodLog := filepath.Join(options.VirtualPodLogsPath, "default_b_uid-b")
+	foreignKubeletPod := filepath.Join(options.VirtualKubeletPodPath, "uid-b")
+	assert.NilError(t, os.Symlink("/var/vcluster/physical/log/pods/ns_a-x-default-x-vcluster_uid-pa", podLog))
+	assert.NilError(t, os.Symlink("/var/log/pods/default_a_uid-a/app/0.log", containerLog))
+	assert.NilError(t, os.Symlink("/var/vcluster/physical/kubelet/pods/uid-pa/volumes", filepath.Join(kubeletPod, "volumes")))
+	assert.NilError(t, os.Symlink("/opt/logs", foreignPodLog))
+	assert.NilError(t, os.Symlink("/var/vcluster/physical/kubelet/pods/uid-pb/volumes", filepath.Join(foreignKubeletPod, "volumes")))
+	assert.NilError(t, os.WriteFile(filepath.Join(foreignKubeletPod, "backup"), nil, 0600))
+
+	// without a state the links of the mapper are adopted
+	owned := loadOwnedPaths(options)
+	assert.Assert(t, owned.owns(podLog))
+	assert.Assert(t, owned.owns(containerLog))
+	assert.Assert(t, owned.owns(kubeletPod))
+	assert.Assert(t, !owned.owns(foreignPodLog))
+	assert.Assert(t, !owned.owns(foreignKubeletPod))
+
+	// a removed directory is forgotten with its entries
+	owned.add(filepath.Join(kubeletPod, "volumes"))
+	owned.remove(kubeletPod)
+	assert.Assert(t, !owned.owns(kubeletPod))
+	assert.Assert(t, !owned.owns(filepath.Join(kubeletPod, "volumes")))
+	assert.NilError(t, owned.save())
+
+	// the state is kept out of the virtual log path the pods mount
+	_, err := os.Stat(filepath.Join(dir, ownershipStateFilename))
+	assert.NilError(t, err)
+	_, err = os.Stat(filepath.Join(options.VirtualLogsPath, ownershipStateFilename))
+	assert.Assert(t, os.IsNotExist(err))
+
+	// the saved state is used instead of adopting the links again
+	owned = loadOwnedPaths(options)
+	assert.Assert(t, owned.owns(podLog))
+	assert.Assert(t, owned.owns(containerLog))
+	assert.Assert(t, !owned.owns(kubeletPod))
+}
+
+func Test_ownedPathsSavedOnChange(t *testing.T) {
+	h := newTestHost(t)
+	stateFile := ownershipStatePath(h.options)
+	exists := func() bool {
+		_, err := os.Stat(stateFile)
+		return err == nil
+	}
+
+	vPod, pPod := h.addPod("web")
+	h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, false, newMapRetries(newPodEvents(h.options, nil)))
+	assert.Assert(t, exists())
+
+	// mapping the same pod again does not write the state
+	assert.NilError(t, os.Remove(stateFile))
+	for i := 0; i < 3; i++ {
+		h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, false, newMapRetries(newPodEvents(h.options, nil)))
+		h.sync([]corev1.Pod{vPod}, []corev1.Pod{pPod}, true, newMapRetries(newPodEvents(h.options, nil)))
+	}
+	assert.Assert(t, !exists())
+
+	otherVPod, otherPPod := h.addPod("other")
+	h.sync([]corev1.Pod{vPod, otherVPod}, []corev1.Pod{pPod, otherPPod}, false, newMapRetries(newPodEvents(h.options, nil)))
+	assert.Assert(t, exists())
+}
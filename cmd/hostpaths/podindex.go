@@ -0,0 +1,106 @@
+package hostpaths
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// physicalPodIndex finds the physical pod of a virtual pod through the
+// object-name, object-namespace and object-uid annotations the syncer sets
+// on physical pods, so the mapping does not depend on how vCluster
+// translates names. Translating the name is only used for pods without
+// these annotations.
+type physicalPodIndex struct {
+	byVirtualUID  map[types.UID]*corev1.Pod
+	byVirtualName map[types.NamespacedName]*corev1.Pod
+	byName        map[types.NamespacedName]*corev1.Pod
+}
+
+func newPhysicalPodIndex(pPods []corev1.Pod) *physicalPodIndex {
+	index := &physicalPodIndex{
+		byVirtualUID:  map[types.UID]*corev1.Pod{},
+		byVirtualName: map[types.NamespacedName]*corev1.Pod{},
+		byName:        map[types.NamespacedName]*corev1.Pod{},
+	}
+
+	for i := range pPods {
+		pPod := &pPods[i]
+		index.byName[types.NamespacedName{Name: pPod.Name, Namespace: pPod.Namespace}] = pPod
+
+		if uid := pPod.Annotations[translate.UIDAnnotation]; uid != "" {
+			index.byVirtualUID[types.UID(uid)] = pPod
+		}
+
+		name, namespace := pPod.Annotations[translate.NameAnnotation], pPod.Annotations[translate.NamespaceAnnotation]
+		if name != "" && namespace != "" {
+			index.byVirtualName[types.NamespacedName{Name: name, Namespace: namespace}] = pPod
+		}
+	}
+
+	return index
+}
+
+// lookup returns the physical pod of the virtual pod or nil if there is none
+func (i *physicalPodIndex) lookup(translator translate.Translator, vPod *corev1.Pod) *corev1.Pod {
+	if pPod, ok := i.byVirtualUID[vPod.UID]; ok {
+		return pPod
+	}
+
+	// a physical pod with a different uid belongs to a previous virtual pod
+	// of the same name, e.g. of a recreated StatefulSet pod
+	vName := types.NamespacedName{Name: vPod.Name, Namespace: vPod.Namespace}
+	if pPod, ok := i.byVirtualName[vName]; ok {
+		if pPod.Annotations[translate.UIDAnnotation] == "" {
+			return pPod
+		}
+
+		return nil
+	}
+
+	pPod, ok := i.byName[translator.HostName(nil, vPod.Name, vPod.Namespace)]
+	if !ok || belongsToOtherPod(pPod, vPod) {
+		return nil
+	}
+
+	return pPod
+}
+
+// belongsToOtherPod checks if the annotations of the physical pod point to
+// a virtual pod other than vPod
+func belongsToOtherPod(pPod, vPod *corev1.Pod) bool {
+	annotations := pPod.Annotations
+	if uid := annotations[translate.UIDAnnotation]; uid != "" && uid != string(vPod.UID) {
+		return true
+	} else if name := annotations[translate.NameAnnotation]; name != "" && name != vPod.Name {
+		return true
+	} else if namespace := annotations[translate.NamespaceAnnotation]; namespace != "" && namespace != vPod.Namespace {
+		return true
+	}
+
+	return false
+}
+
+// getPhysicalPodIndex indexes the physical pods on the current node that
+// live in one of the given host namespaces
+func getPhysicalPodIndex(ctx context.Context, pClient client.Reader, hostNamespaces map[string]struct{}) (*physicalPodIndex, error) {
+	podListOptions := &client.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{
+			NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
+		}),
+	}
+
+	podList := &corev1.PodList{}
+	err := pClient.List(ctx, podList, podListOptions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pods: %w", err)
+	}
+
+	return newPhysicalPodIndex(filter(ctx, podList.Items, hostNamespaces)), nil
+}
@@ -0,0 +1,88 @@
+package hostpaths
+
+import (
+	"testing"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_physicalPodIndex(t *testing.T) {
+	physicalPod := func(name, vName, vNamespace, vUID string) corev1.Pod {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "vcluster-ns",
+			Annotations: map[string]string{},
+		}}
+		if vName != "" {
+			pod.Annotations[translate.NameAnnotation] = vName
+			pod.Annotations[translate.NamespaceAnnotation] = vNamespace
+		}
+		if vUID != "" {
+			pod.Annotations[translate.UIDAnnotation] = vUID
+		}
+
+		return pod
+	}
+	virtualPod := func(name, uid string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID(uid)}}
+	}
+
+	translator := newSingleNamespaceTranslator("vcluster", "vcluster-ns")
+	index := newPhysicalPodIndex([]corev1.Pod{
+		// name differs from the translated name, e.g. a hashed long name
+		physicalPod("hashed-name", "long", "default", "uid-long"),
+		// left over from a previous pod with the same name
+		physicalPod("web-0-x-default-x-vcluster", "web-0", "default", "uid-old"),
+		// synced without annotations
+		physicalPod("legacy-x-default-x-vcluster", "", "", ""),
+		// translated name of "foreign" but synced from another pod
+		physicalPod("foreign-x-default-x-vcluster", "other", "default", "uid-other"),
+		// annotations without uid
+		physicalPod("no-uid", "no-uid", "default", ""),
+	})
+
+	testCases := []struct {
+		name         string
+		vPod         *corev1.Pod
+		expectedName string
+	}{
+		{
+			name:         "Matched by uid annotation",
+			vPod:         virtualPod("long", "uid-long"),
+			expectedName: "hashed-name",
+		},
+		{
+			name:         "Physical pod of previous virtual pod",
+			vPod:         virtualPod("web-0", "uid-new"),
+			expectedName: "",
+		},
+		{
+			name:         "Fallback to name translation",
+			vPod:         virtualPod("legacy", "uid-legacy"),
+			expectedName: "legacy-x-default-x-vcluster",
+		},
+		{
+			name:         "Translated name belongs to other pod",
+			vPod:         virtualPod("foreign", "uid-foreign"),
+			expectedName: "",
+		},
+		{
+			name:         "Matched by name annotations",
+			vPod:         virtualPod("no-uid", "uid-no-uid"),
+			expectedName: "no-uid",
+		},
+	}
+
+	for _, testCase := range testCases {
+		name := ""
+		if pPod := index.lookup(translator, testCase.vPod); pPod != nil {
+			name = pPod.Name
+		}
+
+		assert.Equal(t, name, testCase.expectedName, "Unexpected physical pod in test case %s", testCase.name)
+	}
+}
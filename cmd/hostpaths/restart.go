@@ -0,0 +1,403 @@
+package hostpaths
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RestartAnnotation excludes a pod from the restarts of the init container
+	// if set to "false" and opts it in if set to "true". It is read from the
+	// annotations and labels of the physical pod and the labels of the
+	// virtual pod. Restarts evict the physical pod, so only the
+	// PodDisruptionBudgets of the host cluster are respected, not the ones
+	// within the virtual cluster unless the vCluster syncs them to the host.
+	RestartAnnotation = "vcluster.loft.sh/hostpath-mapper-restart"
+
+	// evictionRetryInterval is the wait between evictions that were rejected
+	// because of a PodDisruptionBudget
+	evictionRetryInterval = 5 * time.Second
+
+	skipReasonOptedOut     = "opted out"
+	skipReasonNotOptedIn   = "not opted in"
+	skipReasonNotManaged   = "not managed by this vCluster"
+	skipReasonNoController = "no controller that would recreate it"
+)
+
+// restartTargetPods restarts the pods on the node that mount the host paths,
+// so they pick up the virtual paths the mapper maintains
+func restartTargetPods(ctx context.Context, options *VirtualClusterOptions, kubeClient kubernetes.Interface, localClient client.Client) error {
+	pPodList := &corev1.PodList{}
+
+	err := localClient.List(ctx, pPodList, &client.ListOptions{
+		FieldSelector: fields.SelectorFromSet(fields.Set{
+			NodeIndexName: os.Getenv(HostpathMapperSelfNodeNameEnvVar),
+		}),
+	})
+
+	if err != nil {
+		klog.Errorf("unable to list pods: %v", err)
+		return err
+	}
+
+	podRestartList := []corev1.Pod{}
+	skippedPods := map[string][]string{}
+
+podLoop:
+	for _, pPod := range pPodList.Items {
+		// skip current pod itself
+		if pPod.Name == os.Getenv(PodNameEnv) {
+			klog.Infof("skipping self pod %s", pPod.Name)
+			continue
+		}
+
+		// in multi namespace mode the cache contains pods of all namespaces
+		if !options.translator.IsTargetedNamespace(nil, pPod.Namespace) {
+			continue
+		}
+
+		klog.Infof("processing pod %s", pPod.Name)
+
+		for _, volume := range pPod.Spec.Volumes {
+			if volume.VolumeSource.HostPath != nil {
+				if volume.VolumeSource.HostPath.Path == podtranslate.PodLoggingHostPath ||
+					volume.VolumeSource.HostPath.Path == podtranslate.LogHostPath ||
+					volume.VolumeSource.HostPath.Path == podtranslate.KubeletPodPath {
+					if reason := restartSkipReason(options, &pPod); reason != "" {
+						klog.Infof("skipping restart of pod %s: %s", pPod.Name, reason)
+						skippedPods[reason] = append(skippedPods[reason], pPod.Namespace+"/"+pPod.Name)
+						continue podLoop
+					}
+
+					klog.Infof("adding pod %s to restart list", pPod.Name)
+					podRestartList = append(podRestartList, pPod)
+					continue podLoop
+				}
+			}
+		}
+	}
+
+	klog.Infof("restart list %d", len(podRestartList))
+	for _, reason := range []string{skipReasonOptedOut, skipReasonNotOptedIn, skipReasonNotManaged, skipReasonNoController} {
+		if len(skippedPods[reason]) > 0 {
+			klog.Infof("skipped %d pods that mount host paths, %s: %s", len(skippedPods[reason]), reason, strings.Join(skippedPods[reason], ", "))
+		}
+	}
+
+	r := &podRestarter{
+		options:    options,
+		kubeClient: kubeClient,
+		client:     localClient,
+	}
+
+	return r.restart(ctx, podRestartList)
+}
+
+// restartSkipReason returns why a pod that mounts the host paths should not
+// be restarted, or an empty string if it should be
+func restartSkipReason(options *VirtualClusterOptions, pPod *corev1.Pod) string {
+	restart, ok := pPod.Annotations[RestartAnnotation]
+	if !ok {
+		restart, ok = pPod.Labels[RestartAnnotation]
+	}
+	if !ok {
+		restart, ok = virtualPodLabels(pPod)[RestartAnnotation]
+	}
+
+	if ok && restart == "false" {
+		return skipReasonOptedOut
+	} else if options.RestartOptIn && restart != "true" {
+		return skipReasonNotOptedIn
+	} else if options.RestartManagedOnly && !isVClusterPod(options, pPod) {
+		return skipReasonNotManaged
+	} else if podControllerUID(pPod) == "" && !options.RestartUnownedPods {
+		// a pod without a controller would not come back after the restart
+		return skipReasonNoController
+	}
+
+	return ""
+}
+
+// isVClusterPod verifies that the physical pod was synced by the vCluster of
+// the options, using the markers the syncer sets on it. Besides the marker
+// label it needs to point to its virtual pod through the uid or the name
+// annotations, like the pod index matches them. The physical name is not
+// compared, as the syncer shortens long names.
+func isVClusterPod(options *VirtualClusterOptions, pPod *corev1.Pod) bool {
+	if !options.translator.IsManaged(nil, pPod) {
+		return false
+	}
+
+	if pPod.Annotations[translate.UIDAnnotation] != "" {
+		return true
+	}
+
+	return pPod.Annotations[translate.NameAnnotation] != "" && pPod.Annotations[translate.NamespaceAnnotation] != ""
+}
+
+// virtualPodLabels returns the labels of the virtual pod, which the syncer
+// stores on the physical pod as lines of key="value"
+func virtualPodLabels(pPod *corev1.Pod) map[string]string {
+	labels := map[string]string{}
+	for _, line := range strings.Split(pPod.Annotations[podtranslate.VClusterLabelsAnnotation], "\n") {
+		key, rawValue, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		value := ""
+		err := json.Unmarshal([]byte(rawValue), &value)
+		if err != nil {
+			continue
+		}
+
+		labels[key] = value
+	}
+
+	return labels
+}
+
+// errRestartHalted is returned for the pods that were not restarted, as the
+// restart of another pod of their controller failed
+var errRestartHalted = errors.New("skipped as the restart of another pod of its controller failed")
+
+// podRestarter evicts pods at a limited rate and waits until their
+// replacements are ready before it continues with the next pod
+type podRestarter struct {
+	options    *VirtualClusterOptions
+	kubeClient kubernetes.Interface
+
+	// client reads from the cache of the local manager
+	client client.Client
+}
+
+func (r *podRestarter) restart(ctx context.Context, pods []corev1.Pod) error {
+	limiter := rate.NewLimiter(rate.Every(r.options.RestartInterval), 1)
+	workers := make(chan struct{}, r.options.RestartConcurrency)
+	wg := sync.WaitGroup{}
+
+	m := sync.Mutex{}
+	restarted := 0
+	errs := []error{}
+
+	// halted are the controllers whose pods are no longer restarted, as the
+	// restart of one of their pods failed, so a broken rollout can't evict
+	// every pod of a workload
+	halted := map[types.UID]bool{}
+
+	for _, pPod := range pods {
+		if r.options.DryRun {
+			reportDryRun(r.options, dryRunAction{Action: dryRunActionRestartPod, Pod: pPod.Namespace + "/" + pPod.Name})
+			continue
+		}
+
+		select {
+		case workers <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		controllerUID := podControllerUID(&pPod)
+		m.Lock()
+		skip := controllerUID != "" && halted[controllerUID]
+		if skip {
+			klog.Warningf("not restarting pod %s/%s: %v", pPod.Namespace, pPod.Name, errRestartHalted)
+			errs = append(errs, fmt.Errorf("restart pod %s/%s: %w", pPod.Namespace, pPod.Name, errRestartHalted))
+		}
+		m.Unlock()
+		if skip {
+			<-workers
+			continue
+		}
+
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		wg.Add(1)
+		go func(pPod corev1.Pod) {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			err := r.restartPod(ctx, &pPod)
+
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				klog.Errorf("error restarting pod %s/%s: %v", pPod.Namespace, pPod.Name, err)
+				errs = append(errs, fmt.Errorf("restart pod %s/%s: %w", pPod.Namespace, pPod.Name, err))
+				if controllerUID != "" {
+					halted[controllerUID] = true
+				}
+			} else {
+				restarted++
+			}
+		}(pPod)
+	}
+
+	wg.Wait()
+	klog.Infof("restarted %d pods, %d restarts failed or were skipped", restarted, len(errs))
+	return errors.Join(errs...)
+}
+
+// restartPod evicts the pod, so PodDisruptionBudgets are respected, and
+// waits until its controller has as many ready pods as before, it fails if
+// that takes longer than the restart timeout
+func (r *podRestarter) restartPod(ctx context.Context, pPod *corev1.Pod) error {
+	controllerUID := podControllerUID(pPod)
+	readyBefore, err := r.countReadyPods(ctx, pPod.Namespace, controllerUID)
+	if err != nil {
+		return err
+	}
+
+	klog.Infof("evicting physical pod %s/%s", pPod.Namespace, pPod.Name)
+	err = r.evict(ctx, pPod)
+	if err != nil {
+		return err
+	}
+
+	if controllerUID == "" || r.options.RestartTimeout == 0 {
+		return nil
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, r.options.RestartTimeout, false, func(ctx context.Context) (bool, error) {
+		current := &corev1.Pod{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: pPod.Namespace, Name: pPod.Name}, current)
+		if err == nil && current.UID == pPod.UID {
+			return false, nil
+		} else if err != nil && !kerrors.IsNotFound(err) {
+			return false, err
+		}
+
+		ready, err := r.countReadyPods(ctx, pPod.Namespace, controllerUID)
+		if err != nil {
+			return false, err
+		}
+
+		return ready >= readyBefore, nil
+	})
+	if err != nil {
+		return fmt.Errorf("replacement did not become ready within %s: %w", r.options.RestartTimeout, err)
+	}
+
+	klog.Infof("replacement of pod %s/%s is ready", pPod.Namespace, pPod.Name)
+	return nil
+}
+
+// evict retries the eviction while a PodDisruptionBudget does not allow it
+func (r *podRestarter) evict(ctx context.Context, pPod *corev1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pPod.Name,
+			Namespace: pPod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{
+			Preconditions: metav1.NewUIDPreconditions(string(pPod.UID)),
+		},
+	}
+
+	timeout := r.options.RestartTimeout
+	if timeout == 0 {
+		timeout = evictionRetryInterval
+	}
+
+	return wait.PollUntilContextTimeout(ctx, evictionRetryInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		err := r.kubeClient.CoreV1().Pods(pPod.Namespace).EvictV1(ctx, eviction)
+		if err != nil {
+			if kerrors.IsNotFound(err) || kerrors.IsConflict(err) {
+				// already gone or replaced
+				return true, nil
+			} else if kerrors.IsTooManyRequests(err) {
+				klog.Infof("eviction of pod %s/%s is blocked by a disruption budget, will retry: %v", pPod.Namespace, pPod.Name, err)
+				return false, nil
+			}
+
+			return false, fmt.Errorf("evict pod: %w", err)
+		}
+
+		return true, nil
+	})
+}
+
+// countReadyPods counts the ready pods in the namespace that are controlled
+// by the controller with the given uid
+func (r *podRestarter) countReadyPods(ctx context.Context, namespace string, controllerUID types.UID) (int, error) {
+	if controllerUID == "" {
+		return 0, nil
+	}
+
+	podList := &corev1.PodList{}
+	err := r.client.List(ctx, podList, client.InNamespace(namespace))
+	if err != nil {
+		return 0, fmt.Errorf("list pods: %w", err)
+	}
+
+	ready := 0
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp == nil && podControllerUID(&pod) == controllerUID && isPodReady(&pod) {
+			ready++
+		}
+	}
+
+	return ready, nil
+}
+
+// podControllerUID returns the uid of the controller of the physical pod.
+// Pods synced by vCluster have no controller in the host cluster, so the
+// owner references of the virtual pod the syncer stores are checked too.
+func podControllerUID(pPod *corev1.Pod) types.UID {
+	if owner := metav1.GetControllerOf(pPod); owner != nil {
+		return owner.UID
+	}
+
+	rawOwnerReferences, ok := pPod.Annotations[podtranslate.OwnerReferences]
+	if !ok {
+		return ""
+	}
+
+	ownerReferences := []metav1.OwnerReference{}
+	err := json.Unmarshal([]byte(rawOwnerReferences), &ownerReferences)
+	if err != nil {
+		klog.Errorf("error parsing owner references of pod %s/%s: %v", pPod.Namespace, pPod.Name, err)
+		return ""
+	}
+
+	for _, owner := range ownerReferences {
+		if owner.Controller != nil && *owner.Controller {
+			return owner.UID
+		}
+	}
+
+	return ""
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,321 @@
+package hostpaths
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	podtranslate "github.com/loft-sh/vcluster/pkg/controllers/resources/pods/translate"
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// podListClient is a client of the local manager that only lists pods
+type podListClient struct {
+	client.Client
+	pods []corev1.Pod
+}
+
+func (c *podListClient) List(_ context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	listOptions := &client.ListOptions{}
+	listOptions.ApplyOptions(opts)
+
+	podList := list.(*corev1.PodList)
+	for _, pod := range c.pods {
+		if listOptions.Namespace == "" || listOptions.Namespace == pod.Namespace {
+			podList.Items = append(podList.Items, pod)
+		}
+	}
+
+	return nil
+}
+
+func Test_podControllerUID(t *testing.T) {
+	testCases := []struct {
+		name        string
+		pod         *corev1.Pod
+		expectedUID types.UID
+	}{
+		{
+			name:        "Bare pod",
+			pod:         &corev1.Pod{},
+			expectedUID: "",
+		},
+		{
+			name: "Host controller",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", UID: "host-rs", Controller: ptr.To(true)}},
+			}},
+			expectedUID: "host-rs",
+		},
+		{
+			name: "Virtual controller",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				OwnerReferences: []metav1.OwnerReference{{Kind: "Service", UID: "vcluster-svc"}},
+				Annotations: map[string]string{
+					podtranslate.OwnerReferences: `[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"rs","uid":"virtual-rs","controller":true}]`,
+				},
+			}},
+			expectedUID: "virtual-rs",
+		},
+		{
+			name: "Virtual owner that is no controller",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					podtranslate.OwnerReferences: `[{"apiVersion":"v1","kind":"ConfigMap","name":"cm","uid":"virtual-cm"}]`,
+				},
+			}},
+			expectedUID: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, podControllerUID(testCase.pod), testCase.expectedUID, "Unexpected controller in test case %s", testCase.name)
+	}
+}
+
+func Test_restartSkipReason(t *testing.T) {
+	controller := []metav1.OwnerReference{{Kind: "ReplicaSet", UID: "rs", Controller: ptr.To(true)}}
+	syncedPod := func(labels, annotations map[string]string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            "pod-x-default-x-vcluster",
+			Namespace:       "vcluster-ns",
+			Labels:          map[string]string{translate.MarkerLabel: "vcluster"},
+			Annotations:     map[string]string{translate.NameAnnotation: "pod", translate.NamespaceAnnotation: "default", translate.UIDAnnotation: "uid"},
+			OwnerReferences: controller,
+		}}
+		for k, v := range labels {
+			pod.Labels[k] = v
+		}
+		for k, v := range annotations {
+			pod.Annotations[k] = v
+		}
+
+		return pod
+	}
+
+	otherVClusterPod := syncedPod(map[string]string{translate.MarkerLabel: "other"}, nil)
+	otherVClusterPod.Name = "pod-x-default-x-other"
+	shortenedPod := syncedPod(nil, nil)
+	shortenedPod.Name = "vcluster-pod-3f2a9c"
+	nameOnlyPod := syncedPod(nil, nil)
+	delete(nameOnlyPod.Annotations, translate.UIDAnnotation)
+	markerOnlyPod := syncedPod(nil, nil)
+	markerOnlyPod.Annotations = nil
+	noControllerPod := syncedPod(nil, nil)
+	noControllerPod.OwnerReferences = nil
+
+	testCases := []struct {
+		name           string
+		options        VirtualClusterOptions
+		pod            *corev1.Pod
+		expectedReason string
+	}{
+		{
+			name:           "Restarted by default",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            syncedPod(nil, nil),
+			expectedReason: "",
+		},
+		{
+			name:           "Opted out by annotation",
+			pod:            syncedPod(nil, map[string]string{RestartAnnotation: "false"}),
+			expectedReason: skipReasonOptedOut,
+		},
+		{
+			name:           "Opted out by virtual label",
+			pod:            syncedPod(nil, map[string]string{podtranslate.VClusterLabelsAnnotation: "app=\"agent\"\n" + RestartAnnotation + "=\"false\""}),
+			expectedReason: skipReasonOptedOut,
+		},
+		{
+			name:           "Not opted in",
+			options:        VirtualClusterOptions{RestartOptIn: true, RestartManagedOnly: true},
+			pod:            syncedPod(nil, nil),
+			expectedReason: skipReasonNotOptedIn,
+		},
+		{
+			name:           "Opted in by label",
+			options:        VirtualClusterOptions{RestartOptIn: true, RestartManagedOnly: true},
+			pod:            syncedPod(map[string]string{RestartAnnotation: "true"}, nil),
+			expectedReason: "",
+		},
+		{
+			name:    "Not managed",
+			options: VirtualClusterOptions{RestartManagedOnly: true},
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:            "agent",
+				Namespace:       "vcluster-ns",
+				OwnerReferences: controller,
+			}},
+			expectedReason: skipReasonNotManaged,
+		},
+		{
+			name:           "Pod of other vCluster in the same namespace",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            otherVClusterPod,
+			expectedReason: skipReasonNotManaged,
+		},
+		{
+			name:           "Name shortened by the syncer",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            shortenedPod,
+			expectedReason: "",
+		},
+		{
+			name:           "Only the name annotations",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            nameOnlyPod,
+			expectedReason: "",
+		},
+		{
+			name:           "Marker label without annotations",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            markerOnlyPod,
+			expectedReason: skipReasonNotManaged,
+		},
+		{
+			name:           "Unmanaged pod if not restricted",
+			pod:            &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "vcluster-ns", OwnerReferences: controller}},
+			expectedReason: "",
+		},
+		{
+			name:           "No controller",
+			options:        VirtualClusterOptions{RestartManagedOnly: true},
+			pod:            noControllerPod,
+			expectedReason: skipReasonNoController,
+		},
+	}
+
+	for _, testCase := range testCases {
+		options := testCase.options
+		options.translator = newSingleNamespaceTranslator("vcluster", "vcluster-ns")
+
+		assert.Equal(t, restartSkipReason(&options, testCase.pod), testCase.expectedReason, "Unexpected skip reason in test case %s", testCase.name)
+	}
+}
+
+func Test_restartTargetPods(t *testing.T) {
+	t.Setenv(PodNameEnv, "mapper")
+	options := &VirtualClusterOptions{RestartConcurrency: 2, RestartManagedOnly: true}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	options.translator = newSingleNamespaceTranslator("vcluster", "vcluster-ns")
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+
+	pod := func(name, namespace, hostPath string, annotations map[string]string) corev1.Pod {
+		pod := corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name + "-x-default-x-vcluster",
+			Namespace:       namespace,
+			Labels:          map[string]string{translate.MarkerLabel: "vcluster"},
+			Annotations:     map[string]string{translate.NameAnnotation: name, translate.NamespaceAnnotation: "default", translate.UIDAnnotation: "uid-" + name},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", UID: "rs", Controller: ptr.To(true)}},
+		}}
+		for k, v := range annotations {
+			pod.Annotations[k] = v
+		}
+		if hostPath != "" {
+			pod.Spec.Volumes = []corev1.Volume{{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: hostPath}}}}
+		}
+
+		return pod
+	}
+
+	self := pod("mapper", "vcluster-ns", podtranslate.LogHostPath, nil)
+	self.Name = "mapper"
+	localClient := &podListClient{pods: []corev1.Pod{
+		self,
+		pod("log-agent", "vcluster-ns", podtranslate.PodLoggingHostPath, nil),
+		pod("backup", "vcluster-ns", podtranslate.KubeletPodPath, nil),
+		pod("web", "vcluster-ns", "", nil),
+		pod("opted-out", "vcluster-ns", podtranslate.LogHostPath, map[string]string{RestartAnnotation: "false"}),
+		pod("other-namespace", "other-ns", podtranslate.LogHostPath, nil),
+	}}
+
+	m := sync.Mutex{}
+	evicted := []string{}
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+
+		m.Lock()
+		defer m.Unlock()
+		evicted = append(evicted, action.(clienttesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+		return true, nil, nil
+	})
+
+	assert.NilError(t, restartTargetPods(ctx, options, kubeClient, localClient))
+
+	sort.Strings(evicted)
+	assert.DeepEqual(t, evicted, []string{"backup-x-default-x-vcluster", "log-agent-x-default-x-vcluster"})
+}
+
+func Test_restartFailures(t *testing.T) {
+	options := &VirtualClusterOptions{RestartConcurrency: 2}
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		name := action.(clienttesting.CreateAction).GetObject().(*policyv1.Eviction).Name
+		if name == "web" {
+			return true, nil, nil
+		}
+
+		return true, nil, kerrors.NewForbidden(corev1.Resource("pods"), name, errors.New("eviction is not allowed"))
+	})
+
+	r := &podRestarter{options: options, kubeClient: kubeClient}
+	pods := []corev1.Pod{}
+	for _, name := range []string{"web", "log-agent", "backup"} {
+		pods = append(pods, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "vcluster-ns"}})
+	}
+
+	err := r.restart(context.Background(), pods)
+	assert.ErrorContains(t, err, "restart pod vcluster-ns/log-agent")
+	assert.ErrorContains(t, err, "restart pod vcluster-ns/backup")
+	assert.Assert(t, !strings.Contains(err.Error(), "vcluster-ns/web"))
+}
+
+func Test_restartHaltsController(t *testing.T) {
+	options := &VirtualClusterOptions{RestartConcurrency: 1, RestartTimeout: 10 * time.Millisecond}
+	kubeClient := fake.NewSimpleClientset()
+	evicted := []string{}
+	kubeClient.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		evicted = append(evicted, action.(clienttesting.CreateAction).GetObject().(*policyv1.Eviction).Name)
+		return true, nil, nil
+	})
+
+	controlledPod := func(name string) corev1.Pod {
+		return corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       "vcluster-ns",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", UID: "uid-web", Controller: ptr.To(true)}},
+		}}
+	}
+	pods := []corev1.Pod{
+		controlledPod("web-1"),
+		controlledPod("web-2"),
+		{ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "vcluster-ns"}},
+	}
+
+	// the replacement of web-1 never becomes ready
+	r := &podRestarter{options: options, kubeClient: kubeClient, client: &podListClient{}}
+	err := r.restart(context.Background(), pods)
+	assert.ErrorContains(t, err, "restart pod vcluster-ns/web-1: replacement did not become ready")
+	assert.Assert(t, errors.Is(err, errRestartHalted))
+	assert.ErrorContains(t, err, "restart pod vcluster-ns/web-2")
+	assert.DeepEqual(t, evicted, []string{"web-1", "debug"})
+}
@@ -0,0 +1,151 @@
+package hostpaths
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// retentionStateFilename is stored in the virtual root of the vCluster
+const retentionStateFilename = ".hostpath-mapper-retention.json"
+
+// retentionState remembers since when the virtual paths of removed pods are
+// orphaned. It is persisted, so a restarted mapper neither removes the paths
+// early nor keeps them longer than the retention period.
+type retentionState struct {
+	// Orphaned maps a virtual path to the time it was first found orphaned
+	Orphaned map[string]time.Time `json:"orphaned"`
+
+	// seen are the paths that were checked during the current cleanup,
+	// the others are no longer orphaned or were removed
+	seen map[string]bool
+}
+
+func retentionStatePath(options *VirtualClusterOptions) string {
+	return filepath.Join(options.VirtualRootPath, retentionStateFilename)
+}
+
+func newRetentionState() *retentionState {
+	return &retentionState{
+		Orphaned: map[string]time.Time{},
+		seen:     map[string]bool{},
+	}
+}
+
+// loadRetentionState reads the persisted state, a missing or corrupt file
+// starts with an empty state
+func loadRetentionState(options *VirtualClusterOptions) *retentionState {
+	state := newRetentionState()
+
+	data, err := options.fs().ReadFile(retentionStatePath(options))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			klog.Errorf("error reading retention state: %v", err)
+		}
+
+		return state
+	}
+
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		klog.Errorf("error parsing retention state %s, starting over: %v", retentionStatePath(options), err)
+		state.Orphaned = map[string]time.Time{}
+	} else if state.Orphaned == nil {
+		state.Orphaned = map[string]time.Time{}
+	}
+
+	return state
+}
+
+// save persists the paths that were found orphaned during the current cleanup
+// and were not removed
+func (r *retentionState) save(options *VirtualClusterOptions) error {
+	if options.DryRun {
+		return nil
+	}
+
+	fsys := options.fs()
+	for path := range r.Orphaned {
+		if _, err := fsys.Lstat(path); !r.seen[path] || err != nil {
+			delete(r.Orphaned, path)
+		}
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so a crash never leaves a truncated state behind
+	statePath := retentionStatePath(options)
+	tmpPath := filepath.Join(filepath.Dir(statePath), "."+filepath.Base(statePath)+".tmp")
+	err = fsys.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("write retention state: %w", err)
+	}
+
+	err = fsys.Rename(tmpPath, statePath)
+	if err != nil {
+		_ = fsys.Remove(tmpPath)
+		return fmt.Errorf("replace retention state: %w", err)
+	}
+
+	return nil
+}
+
+// shouldRemove records that path is orphaned and returns whether it can be
+// removed, which is the case once the retention period passed or the
+// physical files it points to are gone. With RetainKubeletPaths, kubelet
+// paths are kept as long as the physical kubelet directory exists instead,
+// as velero backs up the volumes of pods through them until kubelet cleaned
+// them up.
+func (r *retentionState) shouldRemove(options *VirtualClusterOptions, kind, path string, now time.Time) bool {
+	r.seen[path] = true
+	orphanedSince, ok := r.Orphaned[path]
+	if !ok {
+		orphanedSince = now
+		r.Orphaned[path] = now
+	}
+
+	if !pathResolves(options, path) {
+		klog.V(1).Infof("removing %s, its physical files are gone", path)
+		return true
+	} else if kind == SymlinkKindKubelet && options.RetainKubeletPaths {
+		klog.V(1).Infof("keeping %s of a removed pod while its physical kubelet directory exists", path)
+		return false
+	} else if now.Sub(orphanedSince) >= options.RetentionPeriod {
+		return true
+	}
+
+	klog.V(1).Infof("keeping %s of a removed pod until %s", path, orphanedSince.Add(options.RetentionPeriod).Format(time.RFC3339))
+	return false
+}
+
+// pathResolves returns whether the symlink at path resolves within the
+// mapper. A directory, like the kubelet directory of a virtual pod, resolves
+// if any of its entries does.
+func pathResolves(options *VirtualClusterOptions, path string) bool {
+	fsys := options.fs()
+	target, err := fsys.Readlink(path)
+	if err == nil {
+		_, err = fsys.Stat(mapperPath(options, target))
+		return err == nil
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		return false
+	}
+
+	for _, entry := range entries {
+		if pathResolves(options, filepath.Join(path, entry.Name())) {
+			return true
+		}
+	}
+
+	return false
+}
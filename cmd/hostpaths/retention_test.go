@@ -0,0 +1,123 @@
+package hostpaths
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+func Test_retention(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{
+		VirtualRootPath:       dir,
+		VirtualLogsPath:       filepath.Join(dir, "log"),
+		VirtualPodLogsPath:    filepath.Join(dir, "log", "pods"),
+		VirtualKubeletPodPath: filepath.Join(dir, "kubelet", "pods"),
+		RetentionPeriod:       time.Hour,
+	}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx := context.WithValue(context.Background(), optionsKey, options)
+	ownership.remove(vClusterKey(options))
+	t.Cleanup(func() { ownership.remove(vClusterKey(options)) })
+
+	physicalDir := filepath.Join(dir, "physical")
+	for _, path := range []string{options.VirtualPodLogsPath, filepath.Join(options.VirtualKubeletPodPath, "uid-kept"), filepath.Join(options.VirtualKubeletPodPath, "uid-gone"), physicalDir} {
+		assert.NilError(t, os.MkdirAll(path, 0755))
+	}
+
+	// pod log links of removed pods, one of them with physical files
+	keptPodLog := filepath.Join(options.VirtualPodLogsPath, "default_kept_uid-kept")
+	gonePodLog := filepath.Join(options.VirtualPodLogsPath, "default_gone_uid-gone")
+	assert.NilError(t, os.Symlink(physicalDir, keptPodLog))
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "gone"), gonePodLog))
+
+	// kubelet dirs of removed pods, one of them with physical files
+	keptKubeletPod := filepath.Join(options.VirtualKubeletPodPath, "uid-kept")
+	goneKubeletPod := filepath.Join(options.VirtualKubeletPodPath, "uid-gone")
+	assert.NilError(t, os.Symlink(physicalDir, filepath.Join(keptKubeletPod, "volumes")))
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "gone"), filepath.Join(goneKubeletPod, "volumes")))
+
+	owned := ownership.forVCluster(options)
+	for _, path := range []string{keptPodLog, gonePodLog, keptKubeletPod, goneKubeletPod} {
+		owned.add(path)
+	}
+
+	cleanup := func() {
+		plan := newCleanupPlan(loadRetentionState(options), owned)
+		assert.NilError(t, cleanupOldPodPath(ctx, plan, SymlinkKindPodLog, options.VirtualPodLogsPath, map[string]bool{}))
+		assert.NilError(t, cleanupOldPodPath(ctx, plan, SymlinkKindKubelet, options.VirtualKubeletPodPath, map[string]bool{}))
+		plan.remove(ctx)
+		assert.NilError(t, plan.retention.save(options))
+	}
+	exists := func(path string) bool {
+		_, err := os.Lstat(path)
+		return err == nil
+	}
+
+	// paths with physical files are retained, the others removed right away
+	cleanup()
+	assert.Assert(t, exists(keptPodLog))
+	assert.Assert(t, exists(keptKubeletPod))
+	assert.Assert(t, !exists(gonePodLog))
+	assert.Assert(t, !exists(goneKubeletPod))
+	assert.Assert(t, exists(filepath.Join(dir, retentionStateFilename)))
+	assert.Assert(t, !exists(filepath.Join(options.VirtualLogsPath, retentionStateFilename)))
+
+	// the timestamps survive a restart and are not reset by later cleanups
+	retention := loadRetentionState(options)
+	orphanedSince := retention.Orphaned[keptPodLog]
+	assert.Assert(t, !orphanedSince.IsZero())
+	assert.Equal(t, len(retention.Orphaned), 2)
+
+	cleanup()
+	assert.Assert(t, exists(keptPodLog))
+	assert.Equal(t, loadRetentionState(options).Orphaned[keptPodLog], orphanedSince)
+
+	// once the retention period passed, the paths are removed and forgotten
+	retention.Orphaned[keptPodLog] = orphanedSince.Add(-2 * time.Hour)
+	retention.Orphaned[keptKubeletPod] = orphanedSince.Add(-2 * time.Hour)
+	retention.seen[keptPodLog] = true
+	retention.seen[keptKubeletPod] = true
+	assert.NilError(t, retention.save(options))
+
+	cleanup()
+	assert.Assert(t, !exists(keptPodLog))
+	assert.Assert(t, !exists(keptKubeletPod))
+
+	cleanup()
+	assert.Equal(t, len(loadRetentionState(options).Orphaned), 0)
+}
+
+func Test_retentionRetainKubeletPaths(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{
+		VirtualKubeletPodPath: filepath.Join(dir, "kubelet", "pods"),
+		RetentionPeriod:       time.Hour,
+		RetainKubeletPaths:    true,
+	}
+
+	physicalDir := filepath.Join(dir, "physical")
+	kubeletPod := filepath.Join(options.VirtualKubeletPodPath, "uid-kept")
+	podLog := filepath.Join(dir, "default_kept_uid-kept")
+	assert.NilError(t, os.MkdirAll(kubeletPod, 0755))
+	assert.NilError(t, os.MkdirAll(physicalDir, 0755))
+	assert.NilError(t, os.Symlink(physicalDir, filepath.Join(kubeletPod, "volumes")))
+	assert.NilError(t, os.Symlink(physicalDir, podLog))
+
+	// kubelet paths outlive the retention period while they resolve, the
+	// log paths don't
+	retention := newRetentionState()
+	now := time.Now()
+	retention.Orphaned[kubeletPod] = now.Add(-2 * time.Hour)
+	retention.Orphaned[podLog] = now.Add(-2 * time.Hour)
+	assert.Assert(t, !retention.shouldRemove(options, SymlinkKindKubelet, kubeletPod, now))
+	assert.Assert(t, retention.shouldRemove(options, SymlinkKindPodLog, podLog, now))
+
+	assert.NilError(t, os.RemoveAll(physicalDir))
+	assert.Assert(t, retention.shouldRemove(options, SymlinkKindKubelet, kubeletPod, now))
+}
@@ -0,0 +1,73 @@
+package hostpaths
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// mapRetryBaseDelay and mapRetryMaxDelay bound the exponential backoff
+	// of pods whose paths could not be mapped
+	mapRetryBaseDelay = time.Second
+	mapRetryMaxDelay  = 5 * time.Minute
+)
+
+// mapRetries reports the virtual pods whose paths could not be mapped and
+// retries them through the rate limited queue of the mapper controller, so a
+// single broken pod does not stop the mapping of the others
+type mapRetries struct {
+	podEvents *podEvents
+	events    chan event.GenericEvent
+}
+
+func newMapRetries(podEvents *podEvents) *mapRetries {
+	return &mapRetries{
+		podEvents: podEvents,
+		events:    make(chan event.GenericEvent),
+	}
+}
+
+// newMapRateLimiter backs off retries of the same pod exponentially
+func newMapRateLimiter() workqueue.TypedRateLimiter[reconcile.Request] {
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](mapRetryBaseDelay, mapRetryMaxDelay)
+}
+
+// source enqueues the retried pods with the backoff of the controller queue
+func (m *mapRetries) source() source.Source {
+	return source.Channel(m.events, handler.Funcs{
+		GenericFunc: func(_ context.Context, e event.GenericEvent, queue workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+			queue.AddRateLimited(reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: e.Object.GetNamespace(),
+				Name:      e.Object.GetName(),
+			}})
+		},
+	})
+}
+
+// failed reports that the paths of the virtual pod could not be mapped
+func (m *mapRetries) failed(ctx context.Context, vPod *corev1.Pod, err error) {
+	klog.Errorf("error mapping paths of virtual pod %s/%s: %v", vPod.Namespace, vPod.Name, err)
+	podMappingErrors.WithLabelValues(vClusterLabel(ctx)).Inc()
+	m.podEvents.failed(vPod, err)
+}
+
+// retry requeues the virtual pod with backoff
+func (m *mapRetries) retry(ctx context.Context, vPod *corev1.Pod) {
+	obj := &corev1.Pod{}
+	obj.Namespace = vPod.Namespace
+	obj.Name = vPod.Name
+
+	select {
+	case m.events <- event.GenericEvent{Object: obj}:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,46 @@
+package hostpaths
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func Test_mapRetries(t *testing.T) {
+	options := &VirtualClusterOptions{}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), optionsKey, options))
+	defer cancel()
+
+	recorder := record.NewFakeRecorder(10)
+	retries := newMapRetries(newPodEvents(options, recorder))
+
+	queue := workqueue.NewTypedRateLimitingQueue(newMapRateLimiter())
+	defer queue.ShutDown()
+	assert.NilError(t, retries.source().Start(ctx, queue))
+
+	vPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}}
+	retries.failed(ctx, vPod, errors.New("permission denied"))
+	assert.Equal(t, <-recorder.Events, "Warning "+EventReasonMappingFailed+" Mapping the host paths of the pod failed: permission denied")
+
+	// retries of the same pod back off exponentially
+	retries.retry(ctx, vPod)
+	req, _ := queue.Get()
+	assert.Equal(t, req, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "app"}})
+	assert.Equal(t, queue.NumRequeues(req), 1)
+	queue.Done(req)
+
+	retries.retry(ctx, vPod)
+	req, _ = queue.Get()
+	assert.Equal(t, queue.NumRequeues(req), 2)
+	queue.Done(req)
+}
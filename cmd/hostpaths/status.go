@@ -0,0 +1,363 @@
+package hostpaths
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/loft-sh/vcluster/pkg/util/clienthelper"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+
+	LinkStatusOK         = "ok"
+	LinkStatusDangling   = "dangling"
+	LinkStatusMissing    = "missing"
+	LinkStatusNotSymlink = "not-symlink"
+)
+
+// nodeStatus is the mapping of a vCluster on a single node
+type nodeStatus struct {
+	VCluster string      `json:"vcluster"`
+	Node     string      `json:"node"`
+	Pods     []podStatus `json:"pods"`
+
+	// Orphaned are entries of the virtual paths that belong to no virtual
+	// pod on the node and are removed once their retention period passed
+	Orphaned []linkStatus `json:"orphaned,omitempty"`
+
+	// Foreign are entries of the virtual paths that belong to no virtual
+	// pod but were not created by the mapper, so they are never removed
+	Foreign []linkStatus `json:"foreign,omitempty"`
+}
+
+type podStatus struct {
+	VirtualPod  string       `json:"virtualPod"`
+	PhysicalPod string       `json:"physicalPod,omitempty"`
+	Links       []linkStatus `json:"links"`
+}
+
+type linkStatus struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Target string `json:"target,omitempty"`
+	Status string `json:"status"`
+}
+
+func NewStatusCommand() *cobra.Command {
+	options := &VirtualClusterOptions{}
+	nodeName := ""
+	output := OutputTable
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Print the virtual to physical mapping on the current node",
+		Long: `Prints every virtual pod on the node together with its physical pod,
+the symlinks the mapper created for it and whether they resolve. Needs to be
+run within the hostpath mapper pod, e.g. via kubectl exec.`,
+		Args: cobra.NoArgs,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			if output != OutputTable && output != OutputJSON {
+				return fmt.Errorf("unsupported output %q, use %s or %s", output, OutputTable, OutputJSON)
+			}
+
+			err := validateConnectionOptions(options)
+			if err != nil {
+				return err
+			}
+
+			err = setupHostLayout(cobraCmd.Flags(), options)
+			if err != nil {
+				return err
+			}
+
+			status, err := getNodeStatus(cobraCmd.Context(), options, nodeName)
+			if err != nil {
+				return err
+			}
+
+			return printNodeStatus(cobraCmd.OutOrStdout(), status, output)
+		},
+	}
+
+	addVirtualClusterFlags(cmd.Flags(), options)
+	addHostLayoutFlags(cmd.Flags(), options)
+	cmd.Flags().BoolVar(&options.Central, "central", false, "If the virtual cluster is mapped by the central hostpath mapper")
+	cmd.Flags().StringVar(&nodeName, "node", os.Getenv(HostpathMapperSelfNodeNameEnvVar), "The node the hostpath mapper runs on, defaults to the node of the mapper pod")
+	cmd.Flags().StringVarP(&output, "output", "o", OutputTable, "The output format, table or json")
+
+	return cmd
+}
+
+func getNodeStatus(ctx context.Context, options *VirtualClusterOptions, nodeName string) (*nodeStatus, error) {
+	if nodeName == "" {
+		return nil, fmt.Errorf("node name is empty, set --node or %s", HostpathMapperSelfNodeNameEnvVar)
+	}
+
+	if options.TargetNamespace == "" {
+		currentNamespace, err := clienthelper.CurrentNamespace()
+		if err != nil {
+			return nil, err
+		}
+
+		options.TargetNamespace = currentNamespace
+	}
+
+	setVirtualPaths(options)
+
+	inClusterConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("get in cluster config: %w", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(inClusterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("create kube client: %w", err)
+	}
+
+	err = findVclusterModeAndSetTranslator(ctx, kubeClient, options)
+	if err != nil {
+		return nil, fmt.Errorf("find vcluster mode: %w", err)
+	}
+
+	virtualClusterConfig, err := getVirtualClusterConfig(ctx, kubeClient, options)
+	if err != nil {
+		return nil, err
+	}
+
+	virtualClient, err := kubernetes.NewForConfig(virtualClusterConfig)
+	if err != nil {
+		return nil, fmt.Errorf("create virtual kube client: %w", err)
+	}
+
+	vPodList, err := virtualClient.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(NodeIndexName, nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list virtual pods: %w", err)
+	}
+
+	hostNamespaces := map[string]struct{}{}
+	for _, vPod := range vPodList.Items {
+		hostNamespaces[options.translator.HostNamespace(nil, vPod.Namespace)] = struct{}{}
+	}
+
+	// pods of a namespace syncing vCluster can be in any host namespace
+	pNamespace := ""
+	if options.translator.SingleNamespaceTarget() {
+		pNamespace = options.TargetNamespace
+	}
+
+	pPodList, err := kubeClient.CoreV1().Pods(pNamespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(NodeIndexName, nodeName).String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list physical pods: %w", err)
+	}
+
+	podIndex := newPhysicalPodIndex(filter(ctx, pPodList.Items, hostNamespaces))
+	pPods := map[types.NamespacedName]*corev1.Pod{}
+	for _, vPod := range vPodList.Items {
+		if pPod := podIndex.lookup(options.translator, &vPod); pPod != nil {
+			pPods[types.NamespacedName{Name: vPod.Name, Namespace: vPod.Namespace}] = pPod
+		}
+	}
+
+	status := collectNodeStatus(options, vPodList.Items, pPods)
+	status.Node = nodeName
+	return status, nil
+}
+
+// collectNodeStatus inspects the virtual paths of the given virtual pods,
+// pPods maps the name of a virtual pod to its physical pod
+func collectNodeStatus(options *VirtualClusterOptions, vPods []corev1.Pod, pPods map[types.NamespacedName]*corev1.Pod) *nodeStatus {
+	status := &nodeStatus{
+		VCluster: vClusterKey(options),
+		Pods:     []podStatus{},
+	}
+
+	sort.Slice(vPods, func(i, j int) bool {
+		if vPods[i].Namespace != vPods[j].Namespace {
+			return vPods[i].Namespace < vPods[j].Namespace
+		}
+
+		return vPods[i].Name < vPods[j].Name
+	})
+
+	fsys := options.fs()
+	owned := loadOwnedPaths(options)
+	addUnclaimed := func(link linkStatus, path string) {
+		if owned.owns(path) {
+			status.Orphaned = append(status.Orphaned, link)
+		} else {
+			status.Foreign = append(status.Foreign, link)
+		}
+	}
+
+	containerLinks := readDirNames(fsys, options.VirtualContainerLogsPath)
+	claimedPodLogs := map[string]bool{}
+	claimedKubeletPods := map[string]bool{}
+	claimedContainerLinks := map[string]bool{}
+
+	for _, vPod := range vPods {
+		vPodStatus := podStatus{
+			VirtualPod: vPod.Namespace + "/" + vPod.Name,
+			Links:      []linkStatus{},
+		}
+		if pPod, ok := pPods[types.NamespacedName{Name: vPod.Name, Namespace: vPod.Namespace}]; ok {
+			vPodStatus.PhysicalPod = pPod.Namespace + "/" + pPod.Name
+		}
+
+		// pod log link
+		podLogName := fmt.Sprintf("%s_%s_%s", vPod.Namespace, vPod.Name, vPod.UID)
+		claimedPodLogs[podLogName] = true
+		vPodStatus.Links = append(vPodStatus.Links, getLinkStatus(options, SymlinkKindPodLog, filepath.Join(options.VirtualPodLogsPath, podLogName)))
+
+		// container log links, expected ones first
+		containerPrefix := fmt.Sprintf("%s_%s_", vPod.Name, vPod.Namespace)
+		for _, containerStatus := range allContainerStatuses(&vPod) {
+			for _, containerID := range containerInstanceIDs(containerStatus) {
+				containerLink := fmt.Sprintf(ContainerSymlinkSourceTemplate, vPod.Name, vPod.Namespace, containerStatus.Name, containerID)
+				claimedContainerLinks[containerLink] = true
+				vPodStatus.Links = append(vPodStatus.Links, getLinkStatus(options, SymlinkKindContainerLog, filepath.Join(options.VirtualContainerLogsPath, containerLink)))
+			}
+		}
+		for _, containerLink := range containerLinks {
+			if strings.HasPrefix(containerLink, containerPrefix) && !claimedContainerLinks[containerLink] {
+				claimedContainerLinks[containerLink] = true
+				vPodStatus.Links = append(vPodStatus.Links, getLinkStatus(options, SymlinkKindContainerLog, filepath.Join(options.VirtualContainerLogsPath, containerLink)))
+			}
+		}
+
+		// kubelet links
+		claimedKubeletPods[string(vPod.UID)] = true
+		kubeletPodPath := filepath.Join(options.VirtualKubeletPodPath, string(vPod.UID))
+		kubeletEntries := readDirNames(fsys, kubeletPodPath)
+		if len(kubeletEntries) == 0 {
+			vPodStatus.Links = append(vPodStatus.Links, linkStatus{Kind: SymlinkKindKubelet, Path: kubeletPodPath, Status: LinkStatusMissing})
+		}
+		for _, entry := range kubeletEntries {
+			vPodStatus.Links = append(vPodStatus.Links, getLinkStatus(options, SymlinkKindKubelet, filepath.Join(kubeletPodPath, entry)))
+		}
+
+		status.Pods = append(status.Pods, vPodStatus)
+	}
+
+	for _, podLogName := range readDirNames(fsys, options.VirtualPodLogsPath) {
+		if !claimedPodLogs[podLogName] {
+			path := filepath.Join(options.VirtualPodLogsPath, podLogName)
+			addUnclaimed(getLinkStatus(options, SymlinkKindPodLog, path), path)
+		}
+	}
+	for _, containerLink := range containerLinks {
+		if !claimedContainerLinks[containerLink] {
+			path := filepath.Join(options.VirtualContainerLogsPath, containerLink)
+			addUnclaimed(getLinkStatus(options, SymlinkKindContainerLog, path), path)
+		}
+	}
+	for _, kubeletPod := range readDirNames(fsys, options.VirtualKubeletPodPath) {
+		if claimedKubeletPods[kubeletPod] {
+			continue
+		}
+
+		// the kubelet directory of a pod is owned as a whole
+		kubeletPodPath := filepath.Join(options.VirtualKubeletPodPath, kubeletPod)
+		for _, entry := range readDirNames(fsys, kubeletPodPath) {
+			addUnclaimed(getLinkStatus(options, SymlinkKindKubelet, filepath.Join(kubeletPodPath, entry)), kubeletPodPath)
+		}
+	}
+
+	return status
+}
+
+// getLinkStatus checks whether the symlink at path exists and resolves
+// within the mapper
+func getLinkStatus(options *VirtualClusterOptions, kind, path string) linkStatus {
+	link := linkStatus{
+		Kind: kind,
+		Path: path,
+	}
+
+	fsys := options.fs()
+	target, err := fsys.Readlink(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			link.Status = LinkStatusMissing
+		} else {
+			link.Status = LinkStatusNotSymlink
+		}
+
+		return link
+	}
+
+	link.Target = target
+	_, err = fsys.Stat(mapperPath(options, target))
+	if err != nil {
+		link.Status = LinkStatusDangling
+	} else {
+		link.Status = LinkStatusOK
+	}
+
+	return link
+}
+
+// readDirNames returns the sorted names of the entries of dir, or nothing if
+// it cannot be read
+func readDirNames(fsys hostFS, dir string) []string {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func printNodeStatus(w io.Writer, status *nodeStatus, output string) error {
+	if output == OutputJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "vCluster %s on node %s\n\n", status.VCluster, status.Node)
+	fmt.Fprintln(tw, "VIRTUAL POD\tPHYSICAL POD\tKIND\tPATH\tTARGET\tSTATUS")
+	for _, pod := range status.Pods {
+		physicalPod := pod.PhysicalPod
+		if physicalPod == "" {
+			physicalPod = "<not found>"
+		}
+
+		for _, link := range pod.Links {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", pod.VirtualPod, physicalPod, link.Kind, link.Path, link.Target, link.Status)
+		}
+	}
+	for _, link := range status.Orphaned {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", "<orphaned>", "", link.Kind, link.Path, link.Target, link.Status)
+	}
+	for _, link := range status.Foreign {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", "<foreign>", "", link.Kind, link.Path, link.Target, link.Status)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,89 @@
+package hostpaths
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_collectNodeStatus(t *testing.T) {
+	dir := t.TempDir()
+	options := &VirtualClusterOptions{
+		VirtualRootPath:          dir,
+		VirtualLogsPath:          filepath.Join(dir, "log"),
+		VirtualPodLogsPath:       filepath.Join(dir, "log", "pods"),
+		VirtualContainerLogsPath: filepath.Join(dir, "log", "containers"),
+		VirtualKubeletPodPath:    filepath.Join(dir, "kubelet", "pods"),
+	}
+	options.Name = "vcluster"
+	options.TargetNamespace = "vcluster-ns"
+
+	physicalDir := filepath.Join(dir, "physical")
+	for _, path := range []string{options.VirtualPodLogsPath, options.VirtualContainerLogsPath, filepath.Join(options.VirtualKubeletPodPath, "uid-a"), physicalDir} {
+		assert.NilError(t, os.MkdirAll(path, 0755))
+	}
+
+	// pod a is fully mapped apart from a dangling container link
+	assert.NilError(t, os.Symlink(physicalDir, filepath.Join(options.VirtualPodLogsPath, "default_a_uid-a")))
+	assert.NilError(t, os.Symlink(filepath.Join(dir, "gone"), filepath.Join(options.VirtualContainerLogsPath, "a_default_app-123.log")))
+	assert.NilError(t, os.Symlink(physicalDir, filepath.Join(options.VirtualKubeletPodPath, "uid-a", "volumes")))
+
+	// links of a pod that no longer exists
+	assert.NilError(t, os.Symlink(physicalDir, filepath.Join(options.VirtualPodLogsPath, "default_old_uid-old")))
+	assert.NilError(t, os.WriteFile(ownershipStatePath(options), []byte(`["`+filepath.Join(options.VirtualPodLogsPath, "default_old_uid-old")+`"]`), 0600))
+
+	// a file the mapper did not create
+	assert.NilError(t, os.WriteFile(filepath.Join(options.VirtualContainerLogsPath, "README"), nil, 0600))
+
+	vPods := []corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default", UID: "uid-b"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", UID: "uid-a"},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "app", ContainerID: "containerd://123"}},
+			},
+		},
+	}
+	pPods := map[types.NamespacedName]*corev1.Pod{
+		{Name: "a", Namespace: "default"}: {ObjectMeta: metav1.ObjectMeta{Name: "a-x-default-x-vcluster", Namespace: "vcluster-ns"}},
+	}
+
+	status := collectNodeStatus(options, vPods, pPods)
+
+	expectedPods := []podStatus{
+		{
+			VirtualPod:  "default/a",
+			PhysicalPod: "vcluster-ns/a-x-default-x-vcluster",
+			Links: []linkStatus{
+				{Kind: SymlinkKindPodLog, Path: filepath.Join(options.VirtualPodLogsPath, "default_a_uid-a"), Target: physicalDir, Status: LinkStatusOK},
+				{Kind: SymlinkKindContainerLog, Path: filepath.Join(options.VirtualContainerLogsPath, "a_default_app-123.log"), Target: filepath.Join(dir, "gone"), Status: LinkStatusDangling},
+				{Kind: SymlinkKindKubelet, Path: filepath.Join(options.VirtualKubeletPodPath, "uid-a", "volumes"), Target: physicalDir, Status: LinkStatusOK},
+			},
+		},
+		{
+			VirtualPod: "default/b",
+			Links: []linkStatus{
+				{Kind: SymlinkKindPodLog, Path: filepath.Join(options.VirtualPodLogsPath, "default_b_uid-b"), Status: LinkStatusMissing},
+				{Kind: SymlinkKindKubelet, Path: filepath.Join(options.VirtualKubeletPodPath, "uid-b"), Status: LinkStatusMissing},
+			},
+		},
+	}
+	expectedOrphaned := []linkStatus{
+		{Kind: SymlinkKindPodLog, Path: filepath.Join(options.VirtualPodLogsPath, "default_old_uid-old"), Target: physicalDir, Status: LinkStatusOK},
+	}
+	expectedForeign := []linkStatus{
+		{Kind: SymlinkKindContainerLog, Path: filepath.Join(options.VirtualContainerLogsPath, "README"), Status: LinkStatusNotSymlink},
+	}
+
+	assert.Equal(t, status.VCluster, "vcluster-ns/vcluster")
+	assert.DeepEqual(t, status.Pods, expectedPods)
+	assert.DeepEqual(t, status.Orphaned, expectedOrphaned)
+	assert.DeepEqual(t, status.Foreign, expectedForeign)
+}
@@ -0,0 +1,261 @@
+package hostpaths
+
+import (
+	"crypto/sha256"
+	"sort"
+	"strings"
+
+	"github.com/loft-sh/vcluster/pkg/syncer/synccontext"
+	"github.com/loft-sh/vcluster/pkg/util/base36"
+	"github.com/loft-sh/vcluster/pkg/util/namespaces"
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	_ translate.Translator = &singleNamespace{}
+	_ translate.Translator = &multiNamespace{}
+)
+
+// singleNamespace mirrors the translator of the vCluster syncer for vClusters
+// that sync all objects into their own namespace. Unlike
+// translate.NewSingleNamespaceTranslator it does not depend on the global
+// translate.VClusterName, so several vClusters can be mapped by one process.
+type singleNamespace struct {
+	vClusterName    string
+	targetNamespace string
+}
+
+func newSingleNamespaceTranslator(vClusterName, targetNamespace string) translate.Translator {
+	return &singleNamespace{
+		vClusterName:    vClusterName,
+		targetNamespace: targetNamespace,
+	}
+}
+
+func (s *singleNamespace) SingleNamespaceTarget() bool {
+	return true
+}
+
+func (s *singleNamespace) IsManaged(ctx *synccontext.SyncContext, pObj client.Object) bool {
+	// check if cluster scoped object
+	if pObj.GetNamespace() == "" {
+		return pObj.GetLabels()[translate.MarkerLabel] == s.MarkerLabelCluster()
+	}
+
+	if !s.IsTargetedNamespace(ctx, pObj.GetNamespace()) || pObj.GetLabels()[translate.MarkerLabel] != s.vClusterName {
+		return false
+	}
+
+	return isSyncedObject(pObj)
+}
+
+func (s *singleNamespace) IsTargetedNamespace(_ *synccontext.SyncContext, pNamespace string) bool {
+	return pNamespace == s.targetNamespace
+}
+
+func (s *singleNamespace) MarkerLabelCluster() string {
+	return translate.SafeConcatName(s.targetNamespace, "x", s.vClusterName)
+}
+
+func (s *singleNamespace) HostName(ctx *synccontext.SyncContext, vName, vNamespace string) types.NamespacedName {
+	if vName == "" {
+		return types.NamespacedName{}
+	}
+
+	return types.NamespacedName{
+		Name:      translate.SingleNamespaceHostName(vName, vNamespace, s.vClusterName),
+		Namespace: s.HostNamespace(ctx, vNamespace),
+	}
+}
+
+func (s *singleNamespace) HostNameShort(ctx *synccontext.SyncContext, vName, vNamespace string) types.NamespacedName {
+	if vName == "" {
+		return types.NamespacedName{}
+	}
+
+	// we use base36 to avoid as much conflicts as possible
+	digest := sha256.Sum256([]byte(strings.Join([]string{vName, "x", vNamespace, "x", s.vClusterName}, "-")))
+	return types.NamespacedName{
+		Name:      "v" + base36.EncodeBytes(digest[:])[0:13], // needs to start with a character for certain objects (e.g. services)
+		Namespace: s.HostNamespace(ctx, vNamespace),
+	}
+}
+
+func (s *singleNamespace) HostNameCluster(name string) string {
+	if name == "" {
+		return ""
+	}
+	return translate.SafeConcatName("vcluster", name, "x", s.targetNamespace, "x", s.vClusterName)
+}
+
+func (s *singleNamespace) HostNamespace(_ *synccontext.SyncContext, vNamespace string) string {
+	if vNamespace == "" {
+		return ""
+	}
+
+	return s.targetNamespace
+}
+
+func (s *singleNamespace) LabelsToTranslate() map[string]bool {
+	return map[string]bool{
+		// rewrite release
+		translate.VClusterReleaseLabel: true,
+
+		// namespace, marker & controlled-by
+		translate.NamespaceLabel:  true,
+		translate.MarkerLabel:     true,
+		translate.ControllerLabel: true,
+	}
+}
+
+// multiNamespace translates names for vClusters that sync every virtual
+// namespace into its own host namespace (sync.toHost.namespaces). Object
+// names are kept as they are and namespaces are mapped by mappings.byName.
+type multiNamespace struct {
+	vClusterName    string
+	targetNamespace string
+
+	// mappings of virtual namespace (pattern) to host namespace (pattern)
+	mappings map[string]string
+
+	// patterns are the pattern mappings, most specific first
+	patterns []namespacePattern
+}
+
+// namespacePattern maps the virtual namespaces matching virtual to the host
+// namespaces of host, both with the name placeholder replaced
+type namespacePattern struct {
+	virtual string
+	host    string
+}
+
+func newMultiNamespaceTranslator(vClusterName, targetNamespace string, mappings map[string]string) translate.Translator {
+	var patterns []namespacePattern
+	for vPattern, hPattern := range mappings {
+		if !namespaces.IsPattern(vPattern) || !namespaces.IsPattern(hPattern) {
+			continue
+		}
+
+		patterns = append(patterns, namespacePattern{
+			virtual: namespaces.ProcessNamespaceName(vPattern, vClusterName),
+			host:    namespaces.ProcessNamespaceName(hPattern, vClusterName),
+		})
+	}
+
+	// the most specific pattern, the one with the longest fixed part, wins
+	// if several match. Ties are broken by name, so the host namespace never
+	// depends on the iteration order of the mappings.
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i].virtual) != len(patterns[j].virtual) {
+			return len(patterns[i].virtual) > len(patterns[j].virtual)
+		}
+
+		return patterns[i].virtual < patterns[j].virtual
+	})
+
+	return &multiNamespace{
+		vClusterName:    vClusterName,
+		targetNamespace: targetNamespace,
+		mappings:        mappings,
+		patterns:        patterns,
+	}
+}
+
+func (m *multiNamespace) SingleNamespaceTarget() bool {
+	return false
+}
+
+func (m *multiNamespace) IsManaged(ctx *synccontext.SyncContext, pObj client.Object) bool {
+	// check if cluster scoped object
+	if pObj.GetNamespace() == "" {
+		return pObj.GetLabels()[translate.MarkerLabel] == m.MarkerLabelCluster()
+	}
+
+	if !m.IsTargetedNamespace(ctx, pObj.GetNamespace()) || pObj.GetLabels()[translate.MarkerLabel] != m.vClusterName {
+		return false
+	}
+
+	return isSyncedObject(pObj)
+}
+
+func (m *multiNamespace) IsTargetedNamespace(_ *synccontext.SyncContext, pNamespace string) bool {
+	_, ok := namespaces.TranslateHostNamespace(m.vClusterName, pNamespace, m.mappings)
+	return ok
+}
+
+func (m *multiNamespace) MarkerLabelCluster() string {
+	return translate.SafeConcatName(m.targetNamespace, "x", m.vClusterName)
+}
+
+func (m *multiNamespace) HostName(ctx *synccontext.SyncContext, vName, vNamespace string) types.NamespacedName {
+	if vName == "" {
+		return types.NamespacedName{}
+	}
+
+	return types.NamespacedName{
+		Name:      vName,
+		Namespace: m.HostNamespace(ctx, vNamespace),
+	}
+}
+
+func (m *multiNamespace) HostNameShort(ctx *synccontext.SyncContext, vName, vNamespace string) types.NamespacedName {
+	return m.HostName(ctx, vName, vNamespace)
+}
+
+func (m *multiNamespace) HostNameCluster(name string) string {
+	if name == "" {
+		return ""
+	}
+	return translate.SafeConcatName("vcluster", name, "x", m.targetNamespace, "x", m.vClusterName)
+}
+
+// HostNamespace returns the host namespace for the virtual namespace or an
+// empty string if none of the mappings matches it
+func (m *multiNamespace) HostNamespace(_ *synccontext.SyncContext, vNamespace string) string {
+	if vNamespace == "" {
+		return ""
+	}
+
+	// exact virtual name to exact host name match takes priority
+	for vName, hName := range m.mappings {
+		if namespaces.IsPattern(vName) || namespaces.IsPattern(hName) {
+			continue
+		}
+
+		if namespaces.ProcessNamespaceName(vName, m.vClusterName) == vNamespace {
+			return namespaces.ProcessNamespaceName(hName, m.vClusterName)
+		}
+	}
+
+	for _, pattern := range m.patterns {
+		wildcardValue, matched := namespaces.MatchAndExtractWildcard(vNamespace, pattern.virtual)
+		if matched {
+			return strings.Replace(pattern.host, namespaces.WildcardChar, wildcardValue, 1)
+		}
+	}
+
+	return ""
+}
+
+func (m *multiNamespace) LabelsToTranslate() map[string]bool {
+	// names are not rewritten, so there is nothing that could clash
+	return map[string]bool{}
+}
+
+// isSyncedObject checks the annotations the syncer sets on host objects,
+// vcluster has not synced the object if the name annotation is missing or
+// the host name annotations point to a different object
+func isSyncedObject(pObj client.Object) bool {
+	annotations := pObj.GetAnnotations()
+	if annotations[translate.NameAnnotation] == "" {
+		return false
+	} else if annotations[translate.HostNameAnnotation] != "" && annotations[translate.HostNameAnnotation] != pObj.GetName() {
+		return false
+	} else if annotations[translate.HostNamespaceAnnotation] != "" && annotations[translate.HostNamespaceAnnotation] != pObj.GetNamespace() {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,101 @@
+package hostpaths
+
+import (
+	"testing"
+
+	"github.com/loft-sh/vcluster/pkg/util/translate"
+	"gotest.tools/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_multiNamespaceHostNamespace(t *testing.T) {
+	translator := newMultiNamespaceTranslator("my-vcluster", "vcluster-ns", map[string]string{
+		"default":  "${name}-default",
+		"team-*":   "host-team-*",
+		"static":   "host-static",
+		"other-*x": "host-*-x",
+	})
+
+	testCases := []struct {
+		name              string
+		vNamespace        string
+		expectedNamespace string
+	}{
+		{
+			name:              "Exact mapping with name placeholder",
+			vNamespace:        "default",
+			expectedNamespace: "my-vcluster-default",
+		},
+		{
+			name:              "Exact mapping",
+			vNamespace:        "static",
+			expectedNamespace: "host-static",
+		},
+		{
+			name:              "Pattern mapping",
+			vNamespace:        "team-a",
+			expectedNamespace: "host-team-a",
+		},
+		{
+			name:              "Pattern mapping with suffix",
+			vNamespace:        "other-abcx",
+			expectedNamespace: "host-abc-x",
+		},
+		{
+			name:              "Namespace without mapping",
+			vNamespace:        "kube-system",
+			expectedNamespace: "",
+		},
+	}
+
+	for _, testCase := range testCases {
+		actual := translator.HostNamespace(nil, testCase.vNamespace)
+		assert.Equal(t, actual, testCase.expectedNamespace, "Unexpected result in test case %s", testCase.name)
+
+		if testCase.expectedNamespace != "" {
+			assert.Assert(t, translator.IsTargetedNamespace(nil, actual), "Host namespace not targeted in test case %s", testCase.name)
+			assert.Equal(t, translator.HostName(nil, "pod", testCase.vNamespace).Name, "pod", "Unexpected host name in test case %s", testCase.name)
+		}
+	}
+
+	assert.Assert(t, !translator.IsTargetedNamespace(nil, "vcluster-ns"), "vCluster namespace must not be targeted")
+}
+
+func Test_multiNamespaceHostNamespaceOverlappingPatterns(t *testing.T) {
+	mappings := map[string]string{
+		"team-*":     "host-team-*",
+		"team-a-*":   "host-a-*",
+		"team-a-b-*": "host-ab-*",
+		"*-dev":      "dev-*",
+	}
+
+	// the most specific pattern wins, whatever the order of the map is
+	for i := 0; i < 20; i++ {
+		translator := newMultiNamespaceTranslator("my-vcluster", "vcluster-ns", mappings)
+		assert.Equal(t, translator.HostNamespace(nil, "team-x"), "host-team-x")
+		assert.Equal(t, translator.HostNamespace(nil, "team-a-x"), "host-a-x")
+		assert.Equal(t, translator.HostNamespace(nil, "team-a-b-x"), "host-ab-x")
+		assert.Equal(t, translator.HostNamespace(nil, "team-dev"), "host-team-dev")
+		assert.Equal(t, translator.HostNamespace(nil, "web-dev"), "dev-web")
+	}
+}
+
+func Test_multiNamespaceIsManaged(t *testing.T) {
+	translator := newMultiNamespaceTranslator("my-vcluster", "vcluster-ns", map[string]string{
+		"team-*": "host-team-*",
+	})
+	pod := func(namespace string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:        "pod",
+			Namespace:   namespace,
+			Labels:      labels,
+			Annotations: map[string]string{translate.NameAnnotation: "pod"},
+		}}
+	}
+
+	assert.Assert(t, translator.IsManaged(nil, pod("host-team-a", map[string]string{translate.MarkerLabel: "my-vcluster"})))
+	assert.Assert(t, !translator.IsManaged(nil, pod("host-team-a", nil)), "pods without the marker label are not managed")
+	assert.Assert(t, !translator.IsManaged(nil, pod("host-team-a", map[string]string{translate.MarkerLabel: "other"})), "pods of another vCluster are not managed")
+	assert.Assert(t, !translator.IsManaged(nil, pod("other", map[string]string{translate.MarkerLabel: "my-vcluster"})), "pods outside the mapped namespaces are not managed")
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/loft-sh/vcluster-hostpath-mapper/cmd/hostpaths"
 	"github.com/loft-sh/vcluster/pkg/util/log"
+	"github.com/spf13/cobra"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -29,8 +30,17 @@ func main() {
 		ctrl.SetLogger(log.NewLog(2))
 	}
 
-	// create a new command and execute
-	err := hostpaths.NewHostpathMapperCommand().ExecuteContext(context.Background())
+	// create the root command with the mapper and its tools as subcommands
+	// and execute
+	rootCmd := &cobra.Command{
+		Use:   "vcluster-hpm",
+		Short: "Maps the host paths of vcluster pods on a node",
+	}
+	rootCmd.AddCommand(hostpaths.NewHostpathMapperCommand())
+	rootCmd.AddCommand(hostpaths.NewStatusCommand())
+	rootCmd.AddCommand(hostpaths.NewDoctorCommand())
+
+	err := rootCmd.ExecuteContext(context.Background())
 	if err != nil {
 		klog.Fatal(err)
 	}